@@ -37,8 +37,9 @@ func (c *HTTPClient) NewRequest(method, url string, body io.Reader) (*http.Reque
 	if err != nil {
 		return nil, err
 	}
-	if c.Header != nil {
-		req.Header = c.Header
+	// 复制一份请求头，避免并发请求修改同一个map
+	for key, vals := range c.Header {
+		req.Header[key] = append([]string(nil), vals...)
 	}
 	return req, nil
 }
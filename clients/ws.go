@@ -1,91 +1,223 @@
 package clients
 
 import (
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/haruno-bot/haruno/logger"
 )
 
+// 重连相关的默认值
+const (
+	defaultReconnectInterval    = time.Second
+	defaultMaxReconnectInterval = time.Minute
+	// stableConnTime 连接保持超过这个时间后，重连间隔会重置为最小值
+	stableConnTime = time.Minute
+)
+
+// 保活相关的默认值
+const (
+	defaultWriteTimeout = 10 * time.Second
+	defaultPingInterval = 5 * time.Second
+	defaultPongTimeout  = 30 * time.Second
+	// defaultHandshakeTimeout 建立连接的默认超时时间
+	defaultHandshakeTimeout = 10 * time.Second
+)
+
 // WSClient 拓展的websocket客户端，可以自动重连
 // 这个没有默认的客户端
+// 断线后按指数退避重连，间隔从 ReconnectInterval 开始翻倍，最大为 MaxReconnectInterval
+// 每隔 PingInterval 发送一次ping，超过 PongTimeout 没有收到任何数据时断开连接
+// Proxy 为连接使用的代理，如 http://127.0.0.1:1080 或 socks5://127.0.0.1:1080
+// MaxRetries > 0 时连续重连失败这么多次之后放弃重连，并调用 OnGiveUp
+// OnMessage 在读取协程中按消息到达的顺序调用
 type WSClient struct {
-	Name      string
-	OnMessage func([]byte)
-	OnError   func(error)
-	OnConnect func(*WSClient)
-	Filter    func([]byte) bool
-	headers   http.Header
-	conn      *websocket.Conn
-	url       string
-	closed    bool
-	rquit     chan int
-	wquit     chan int
-	dialer    *websocket.Dialer
-	mmu       sync.Mutex
-	cmu       sync.Mutex
+	Name                 string
+	OnMessage            func([]byte)
+	OnError              func(error)
+	OnConnect            func(*WSClient)
+	OnDisconnect         func()
+	OnGiveUp             func(*WSClient)
+	Filter               func([]byte) bool
+	ReconnectInterval    time.Duration
+	MaxReconnectInterval time.Duration
+	WriteTimeout         time.Duration
+	PingInterval         time.Duration
+	PongTimeout          time.Duration
+	HandshakeTimeout     time.Duration
+	MaxRetries           int
+	Proxy                string
+	TLSClientConfig      *tls.Config
+	headers              http.Header
+	conn                 *websocket.Conn
+	url                  string
+	closed               bool
+	rquit                chan int
+	wquit                chan int
+	connectedAt          time.Time
+	backoff              time.Duration
+	passive              bool
+	shutdown             int32
+	// mmu 保护连接的状态(conn、closed、url等)，只在读写这些字段时短暂持有
+	mmu sync.Mutex
+	// wmu 保证同一时间只有一个协程在写连接
+	wmu sync.Mutex
+	// cmu 保证同一时间只有一个协程在处理断线和重连
+	cmu sync.Mutex
+	emu sync.Mutex
 }
 
 // Dial 设置和远程服务器链接
 func (c *WSClient) Dial(url string, headers http.Header) error {
+	c.mmu.Lock()
 	c.closed = true
 	c.url = url
 	c.headers = headers
-	if c.Name == "" {
-		c.Name = "Websocket"
+	c.mmu.Unlock()
+	dialer := &websocket.Dialer{
+		Proxy:            c.proxy,
+		HandshakeTimeout: durationOr(c.HandshakeTimeout, defaultHandshakeTimeout),
+		TLSClientConfig:  c.TLSClientConfig,
 	}
-	if c.dialer == nil {
-		c.dialer = &websocket.Dialer{
-			Proxy: http.ProxyFromEnvironment,
-		}
+	conn, _, err := dialer.Dial(url, headers)
+	if err != nil {
+		return fmt.Errorf("%s failed to connect %s: %v", c.Name, url, err)
 	}
-	var err error
-	if c.conn, _, err = c.dialer.Dial(url, headers); err != nil {
-		return err
+	c.serve(conn)
+	return nil
+}
+
+// proxy 选择连接使用的代理
+// 优先使用 Proxy，其次是 HTTP_PROXY/HTTPS_PROXY/NO_PROXY 环境变量，最后是 ALL_PROXY 环境变量
+func (c *WSClient) proxy(req *http.Request) (*url.URL, error) {
+	if c.Proxy != "" {
+		return url.Parse(c.Proxy)
 	}
-	c.closed = false
-	c.rquit = make(chan int)
-	c.wquit = make(chan int)
-	if c.OnConnect != nil {
-		go c.OnConnect(c)
+	proxyURL, err := http.ProxyFromEnvironment(req)
+	if err != nil || proxyURL != nil {
+		return proxyURL, err
+	}
+	if all := os.Getenv("ALL_PROXY"); all != "" {
+		return url.Parse(all)
+	}
+	return nil, nil
+}
+
+// DialWithRetry 和 Dial 相同，但是第一次连接失败时会在后台按照断线重连的方式继续尝试
+func (c *WSClient) DialWithRetry(url string, headers http.Header) error {
+	err := c.Dial(url, headers)
+	if err != nil {
+		go func() {
+			c.cmu.Lock()
+			defer c.cmu.Unlock()
+			c.redial()
+		}()
+	}
+	return err
+}
+
+// Accept 使用一个由服务端接受的连接(反向websocket)
+// 这种连接断开后不会重连，而是等待对方重新连接
+// 已有的连接会被关闭
+func (c *WSClient) Accept(conn *websocket.Conn) {
+	c.cmu.Lock()
+	c.passive = true
+	c.cmu.Unlock()
+	c.mmu.Lock()
+	old := c.conn
+	c.mmu.Unlock()
+	c.serve(conn)
+	if old != nil {
+		old.Close()
 	}
+}
+
+// serve 开始读取连接上的消息并定时发送心跳
+func (c *WSClient) serve(conn *websocket.Conn) {
+	if c.Name == "" {
+		c.Name = "Websocket"
+	}
+	rquit := make(chan int)
+	wquit := make(chan int)
+	c.mmu.Lock()
+	c.conn = conn
+	c.rquit = rquit
+	c.wquit = wquit
+	c.connectedAt = time.Now()
+	c.closed = false
+	c.mmu.Unlock()
+	// 收到pong或者任何消息都会延长读超时
+	pongTimeout := durationOr(c.PongTimeout, defaultPongTimeout)
+	conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
 	go func() {
+		// OnConnect 和 OnDisconnect 在同一个协程里先后调用
+		// 每次连接各调用一次，并且不会并发执行
+		if c.OnConnect != nil {
+			c.emu.Lock()
+			c.OnConnect(c)
+			c.emu.Unlock()
+		}
 		for {
-			var msg []byte
-			if _, msg, err = c.conn.ReadMessage(); err != nil {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
 				if c.OnError != nil {
 					go c.OnError(err)
 				}
-				close(c.rquit)
+				if c.OnDisconnect != nil {
+					c.emu.Lock()
+					c.OnDisconnect()
+					c.emu.Unlock()
+				}
+				close(rquit)
 				return
 			}
+			conn.SetReadDeadline(time.Now().Add(pongTimeout))
 			if c.Filter != nil {
 				if !c.Filter(msg) {
 					continue
 				}
 			}
+			// 在读取协程中按收到的顺序依次处理，OnMessage 不应该长时间阻塞
 			if c.OnMessage != nil {
-				go c.OnMessage(msg)
+				c.OnMessage(msg)
 			}
 		}
 	}()
-	go c.setupPing()
-	return nil
+	go c.setupPing(conn, rquit, wquit)
 }
 
 // Send 发送消息
+// 可以在多个协程中同时调用，同一时间只有一个协程在写连接
 func (c *WSClient) Send(msgType int, msg []byte) error {
-	if c.closed {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	// 写的时候连接可能被换掉，写到旧连接上只会失败
+	c.mmu.Lock()
+	conn, wquit, closed := c.conn, c.wquit, c.closed
+	c.mmu.Unlock()
+	if closed || conn == nil {
 		return errors.New("can not use closed connection")
 	}
-	c.mmu.Lock()
-	defer c.mmu.Unlock()
-	err := c.conn.WriteMessage(msgType, msg)
+	conn.SetWriteDeadline(time.Now().Add(durationOr(c.WriteTimeout, defaultWriteTimeout)))
+	err := conn.WriteMessage(msgType, msg)
 	if err != nil {
-		close(c.wquit)
+		// 多个协程可能同时写失败，wquit 只能关闭一次
+		select {
+		case <-wquit:
+		default:
+			close(wquit)
+		}
 		if c.OnError != nil {
 			go c.OnError(err)
 		}
@@ -94,40 +226,158 @@ func (c *WSClient) Send(msgType int, msg []byte) error {
 	return nil
 }
 
+// Retarget 修改重连使用的地址并断开当前的连接
+// 主动连接的客户端随后会连接到新的地址
+func (c *WSClient) Retarget(url string) {
+	c.mmu.Lock()
+	c.url = url
+	conn := c.conn
+	c.mmu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// URL 当前连接(或者正在重连)的地址
+func (c *WSClient) URL() string {
+	c.mmu.Lock()
+	defer c.mmu.Unlock()
+	return c.url
+}
+
+// Reconnect 断开当前的连接
+// 主动连接的客户端随后会自动重连，反向连接的客户端会等待对方重新连接
+func (c *WSClient) Reconnect() {
+	c.mmu.Lock()
+	conn := c.conn
+	c.mmu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// Close 关闭连接，之后不会再重连
+func (c *WSClient) Close() {
+	atomic.StoreInt32(&c.shutdown, 1)
+	c.mmu.Lock()
+	conn := c.conn
+	c.mmu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
 // IsConnected 检查是否在连接状态
+// 还没有建立过连接的客户端不在连接状态
 func (c *WSClient) IsConnected() bool {
-	return !c.closed
+	c.mmu.Lock()
+	defer c.mmu.Unlock()
+	return c.conn != nil && !c.closed
 }
 
-func (c *WSClient) close() {
+// close 关闭连接 conn，主动连接的客户端会在关闭后重连
+// conn 已经不是当前连接时只关闭它本身
+func (c *WSClient) close(conn *websocket.Conn) {
 	c.cmu.Lock()
 	defer c.cmu.Unlock()
-	if c.closed {
+	c.mmu.Lock()
+	current := !c.closed && c.conn == conn
+	if current {
+		c.closed = true
+	}
+	connectedAt := c.connectedAt
+	c.mmu.Unlock()
+	conn.Close()
+	if !current {
 		return
 	}
-	if c.conn != nil {
-		c.conn.Close()
+	if atomic.LoadInt32(&c.shutdown) == 1 {
+		return
 	}
-	c.closed = true
-	for {
-		if err := c.Dial(c.url, c.headers); err == nil {
+	if c.passive {
+		logger.Logger.Printf("%s has broken down, waiting for a new connection.\n", c.Name)
+		return
+	}
+	// 连接稳定保持了一段时间，重连间隔从最小值开始
+	if time.Since(connectedAt) >= stableConnTime {
+		c.backoff = 0
+	}
+	c.redial()
+}
+
+// redial 按指数退避不断重连，直到连接成功
+// 设置了 MaxRetries 时失败这么多次之后放弃
+// 调用时需要持有 cmu
+func (c *WSClient) redial() {
+	for retries := 0; atomic.LoadInt32(&c.shutdown) == 0; retries++ {
+		if c.MaxRetries > 0 && retries >= c.MaxRetries {
+			logger.Logger.Warnf("%s failed to reconnect %d times, give up.\n", c.Name, retries)
+			// 之后重新开始的重连(比如切换到备用地址)从最小间隔开始
+			c.backoff = 0
+			if c.OnGiveUp != nil {
+				go c.OnGiveUp(c)
+			}
+			return
+		}
+		wait := c.nextBackoff()
+		logger.Logger.Printf("%s has broken down, will reconnect after %v.\n", c.Name, wait)
+		time.Sleep(wait)
+		if atomic.LoadInt32(&c.shutdown) == 1 {
+			return
+		}
+		c.mmu.Lock()
+		target, headers := c.url, c.headers
+		c.mmu.Unlock()
+		err := c.Dial(target, headers)
+		if err == nil {
 			return
 		}
-		logger.Logger.Println(c.Name, "has broken down, will reconnect after 5s.")
-		time.Sleep(time.Second * 5)
+		logger.Logger.Errorln(err)
+	}
+}
+
+// durationOr d <= 0 时返回默认值 def
+func durationOr(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
+
+// nextBackoff 计算下一次重连前等待的时间
+func (c *WSClient) nextBackoff() time.Duration {
+	minInterval := c.ReconnectInterval
+	if minInterval <= 0 {
+		minInterval = defaultReconnectInterval
+	}
+	maxInterval := c.MaxReconnectInterval
+	if maxInterval < minInterval {
+		maxInterval = defaultMaxReconnectInterval
+		if maxInterval < minInterval {
+			maxInterval = minInterval
+		}
+	}
+	if c.backoff < minInterval {
+		c.backoff = minInterval
+	} else {
+		c.backoff *= 2
+	}
+	if c.backoff > maxInterval {
+		c.backoff = maxInterval
 	}
+	return c.backoff
 }
 
-func (c *WSClient) setupPing() {
-	ticker := time.NewTicker(time.Second * 5)
+func (c *WSClient) setupPing(conn *websocket.Conn, rquit, wquit chan int) {
+	ticker := time.NewTicker(durationOr(c.PingInterval, defaultPingInterval))
 	pingMsg := []byte("")
 	defer ticker.Stop()
-	defer c.close()
+	defer c.close(conn)
 	for {
 		select {
-		case <-c.rquit:
+		case <-rquit:
 			return
-		case <-c.wquit:
+		case <-wquit:
 			return
 		case <-ticker.C:
 			if c.Send(websocket.PingMessage, pingMsg) != nil {
@@ -0,0 +1,434 @@
+package clients
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newEchoServer 启动一个 websocket 服务，把收到的文本消息写入返回的管道
+func newEchoServer(t *testing.T) (*httptest.Server, chan string) {
+	t.Helper()
+	received := make(chan string, 1024)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(msg)
+		}
+	}))
+	return srv, received
+}
+
+func TestConcurrentSend(t *testing.T) {
+	srv, received := newEchoServer(t)
+	defer srv.Close()
+	client := &WSClient{
+		Name:         "Test",
+		PingInterval: 5 * time.Millisecond,
+	}
+	if err := client.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	const writers, perWriter = 32, 25
+	var wg sync.WaitGroup
+	wg.Add(writers)
+	for i := 0; i < writers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < perWriter; j++ {
+				msg := fmt.Sprintf("%d-%d-%s", i, j, strings.Repeat("x", 512))
+				if err := client.Send(websocket.TextMessage, []byte(msg)); err != nil {
+					t.Errorf("send %d-%d failed: %v", i, j, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	seen := make(map[string]bool)
+	timeout := time.After(5 * time.Second)
+	for len(seen) < writers*perWriter {
+		select {
+		case msg := <-received:
+			var i, j int
+			if _, err := fmt.Sscanf(msg, "%d-%d-", &i, &j); err != nil || !strings.HasSuffix(msg, strings.Repeat("x", 512)) {
+				t.Fatalf("corrupted message %.40q", msg)
+			}
+			key := fmt.Sprintf("%d-%d", i, j)
+			if seen[key] {
+				t.Fatalf("message %s is received twice", key)
+			}
+			seen[key] = true
+		case <-timeout:
+			t.Fatalf("only %d of %d messages are received", len(seen), writers*perWriter)
+		}
+	}
+}
+
+func TestBackoffResetAfterGiveUp(t *testing.T) {
+	gaveUp := make(chan *WSClient, 1)
+	client := &WSClient{
+		Name:                 "Test",
+		ReconnectInterval:    5 * time.Millisecond,
+		MaxReconnectInterval: 20 * time.Millisecond,
+		MaxRetries:           4,
+		OnGiveUp: func(c *WSClient) {
+			gaveUp <- c
+		},
+	}
+	defer client.Close()
+	// 没有服务监听的端口，每次连接都会失败
+	if err := client.DialWithRetry("ws://127.0.0.1:1", nil); err == nil {
+		t.Fatal("dial should fail")
+	}
+	select {
+	case <-gaveUp:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client does not give up")
+	}
+	client.cmu.Lock()
+	defer client.cmu.Unlock()
+	if client.backoff != 0 {
+		t.Errorf("backoff is %v after giving up, want it to be reset", client.backoff)
+	}
+	if wait := client.nextBackoff(); wait != client.ReconnectInterval {
+		t.Errorf("next reconnect waits %v, want %v", wait, client.ReconnectInterval)
+	}
+}
+
+func TestConcurrentSendWhileReconnecting(t *testing.T) {
+	srv, received := newEchoServer(t)
+	defer srv.Close()
+	client := &WSClient{
+		Name:                 "Test",
+		PingInterval:         5 * time.Millisecond,
+		ReconnectInterval:    time.Millisecond,
+		MaxReconnectInterval: 20 * time.Millisecond,
+	}
+	if err := client.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	// 不断断开连接，客户端会自动重连
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			client.Reconnect()
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	const writers = 16
+	sent := new(int32)
+	var swg sync.WaitGroup
+	swg.Add(writers)
+	for i := 0; i < writers; i++ {
+		go func(i int) {
+			defer swg.Done()
+			for j := 0; ; j++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				// 重连期间发送失败是正常的
+				msg := fmt.Sprintf("%d-%d-%s", i, j, strings.Repeat("x", 512))
+				if client.Send(websocket.TextMessage, []byte(msg)) == nil {
+					atomic.AddInt32(sent, 1)
+				}
+				client.IsConnected()
+				client.URL()
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(done)
+	swg.Wait()
+	deadline := time.Now().Add(2 * time.Second)
+	for !client.IsConnected() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !client.IsConnected() {
+		t.Fatal("client does not reconnect")
+	}
+	if err := client.Send(websocket.TextMessage, []byte("last")); err != nil {
+		t.Fatalf("send after reconnecting failed: %v", err)
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-received:
+			if msg == "last" {
+				return
+			}
+			if !strings.HasSuffix(msg, strings.Repeat("x", 512)) {
+				t.Fatalf("corrupted message %.40q", msg)
+			}
+		case <-timeout:
+			t.Fatal("message sent after reconnecting is not received")
+		}
+	}
+}
+
+// newStalledServer 启动一个接受连接之后既不读取消息也不回应ping的 websocket 服务
+// 返回的函数让服务端放开所有连接
+func newStalledServer(t *testing.T) (*httptest.Server, func()) {
+	t.Helper()
+	release := make(chan struct{})
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		<-release
+	}))
+	var once sync.Once
+	return srv, func() {
+		once.Do(func() {
+			close(release)
+		})
+	}
+}
+
+func TestStalledPeerDisconnectsAfterPongTimeout(t *testing.T) {
+	srv, release := newStalledServer(t)
+	defer srv.Close()
+	defer release()
+	const pongTimeout = 100 * time.Millisecond
+	disconnected := make(chan time.Time, 1)
+	client := &WSClient{
+		Name:              "Test",
+		PingInterval:      10 * time.Millisecond,
+		PongTimeout:       pongTimeout,
+		ReconnectInterval: 10 * time.Millisecond,
+		OnDisconnect: func() {
+			select {
+			case disconnected <- time.Now():
+			default:
+			}
+		},
+	}
+	start := time.Now()
+	if err := client.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	select {
+	case at := <-disconnected:
+		// ping 能写出去，但是收不到 pong，只能等读超时
+		if elapsed := at.Sub(start); elapsed < pongTimeout {
+			t.Errorf("client disconnects after %v, before the pong timeout %v", elapsed, pongTimeout)
+		}
+	case <-time.After(20 * pongTimeout):
+		t.Fatal("client does not disconnect from a stalled peer")
+	}
+}
+
+func TestSendToStalledPeerTimesOut(t *testing.T) {
+	srv, release := newStalledServer(t)
+	defer srv.Close()
+	defer release()
+	const writeTimeout = 100 * time.Millisecond
+	client := &WSClient{
+		Name:              "Test",
+		WriteTimeout:      writeTimeout,
+		PongTimeout:       time.Minute,
+		ReconnectInterval: time.Minute,
+	}
+	if err := client.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	// 对方不读取，写满缓冲区之后写操作会阻塞到超时
+	msg := []byte(strings.Repeat("x", 1<<20))
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		start := time.Now()
+		err := client.Send(websocket.BinaryMessage, msg)
+		if err == nil {
+			continue
+		}
+		if elapsed := time.Since(start); elapsed < writeTimeout {
+			t.Errorf("send fails after %v, before the write timeout %v: %v", elapsed, writeTimeout, err)
+		}
+		if client.Send(websocket.TextMessage, []byte("again")) == nil {
+			t.Error("send after a write timeout should fail")
+		}
+		return
+	}
+	t.Fatal("send to a stalled peer never fails")
+}
+
+func TestDialHandshakeTimeout(t *testing.T) {
+	// 接受 TCP 连接但是从不回应握手请求
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var mu sync.Mutex
+	var conns []net.Conn
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	const handshakeTimeout = 100 * time.Millisecond
+	client := &WSClient{
+		Name:             "Test",
+		HandshakeTimeout: handshakeTimeout,
+	}
+	defer client.Close()
+	start := time.Now()
+	err = client.Dial("ws://"+ln.Addr().String(), nil)
+	elapsed := time.Since(start)
+	if err == nil {
+		t.Fatal("dial should fail when the handshake never completes")
+	}
+	if elapsed < handshakeTimeout || elapsed > 10*handshakeTimeout {
+		t.Errorf("dial returns after %v, want about %v", elapsed, handshakeTimeout)
+	}
+	if client.IsConnected() {
+		t.Error("client should not be connected after a failed dial")
+	}
+}
+
+// newFakeProxy 启动一个只支持 CONNECT 的 HTTP 代理，返回代理的地址和经过代理的连接数
+func newFakeProxy(t *testing.T) (string, *int32, func()) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tunnels := new(int32)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				req, err := http.ReadRequest(br)
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					fmt.Fprint(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer target.Close()
+				atomic.AddInt32(tunnels, 1)
+				fmt.Fprint(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+				go io.Copy(target, br)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+	return "http://" + ln.Addr().String(), tunnels, func() {
+		ln.Close()
+	}
+}
+
+// setenv 设置环境变量，返回的函数恢复原来的值
+func setenv(key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestDialThroughProxy(t *testing.T) {
+	srv, received := newEchoServer(t)
+	defer srv.Close()
+	configured, configuredTunnels, closeConfigured := newFakeProxy(t)
+	defer closeConfigured()
+	env, envTunnels, closeEnv := newFakeProxy(t)
+	defer closeEnv()
+	target := "ws" + strings.TrimPrefix(srv.URL, "http")
+	dial := func(proxy string) {
+		t.Helper()
+		client := &WSClient{
+			Name:  "Test",
+			Proxy: proxy,
+		}
+		if err := client.Dial(target, nil); err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		if err := client.Send(websocket.TextMessage, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatal("message sent through the proxy is not received")
+		}
+	}
+
+	// Proxy 优先于环境变量
+	restore := setenv("HTTP_PROXY", env)
+	dial(configured)
+	restore()
+	if n := atomic.LoadInt32(configuredTunnels); n != 1 {
+		t.Errorf("configured proxy tunnels %d connections, want 1", n)
+	}
+	if n := atomic.LoadInt32(envTunnels); n != 0 {
+		t.Errorf("HTTP_PROXY is used although Proxy is set")
+	}
+
+	// 没有其他代理设置时使用 ALL_PROXY
+	defer setenv("ALL_PROXY", env)()
+	dial("")
+	if n := atomic.LoadInt32(envTunnels); n != 1 {
+		t.Errorf("ALL_PROXY tunnels %d connections, want 1", n)
+	}
+	if n := atomic.LoadInt32(configuredTunnels); n != 1 {
+		t.Errorf("configured proxy is used by a client without Proxy")
+	}
+}
@@ -1,5 +1,7 @@
 package coolq
 
+import "encoding/json"
+
 // 文档: https://cqhttp.cc/docs/4.4/#/API?id=api-列表
 // 大致先做这些...
 const (
@@ -7,12 +9,38 @@ const (
 	ActionSendPrivateMsg = "send_private_msg" // DONE: websocket
 	// ActionSendGroupMsg 发送群消息
 	ActionSendGroupMsg = "send_group_msg" // DONE: websocket
+	// ActionSendGroupForwardMsg 发送合并转发(群)
+	ActionSendGroupForwardMsg = "send_group_forward_msg" // DONE: websocket
+	// ActionSendMsg 发送消息
+	ActionSendMsg = "send_msg" // DONE: websocket
+	// ActionDeleteMsg 撤回消息
+	ActionDeleteMsg = "delete_msg" // DONE: websocket
 	// ActionSetGroupKick 群组踢人
 	ActionSetGroupKick = "set_group_kick" // DONE: websocket
 	// ActionSetGroupBan 群组单人禁言
 	ActionSetGroupBan = "set_group_ban" // DONE: websocket
 	// ActionSetGroupWholeBan 群组全员禁言
 	ActionSetGroupWholeBan = "set_group_whole_ban" // DONE: websocket
+	// ActionSetGroupCard 设置群名片
+	ActionSetGroupCard = "set_group_card" // DONE: websocket
+	// ActionSetGroupName 设置群名
+	ActionSetGroupName = "set_group_name" // DONE: websocket
+	// ActionSetGroupLeave 退出群组
+	ActionSetGroupLeave = "set_group_leave" // DONE: websocket
+	// ActionSendLike 发送好友赞
+	ActionSendLike = "send_like" // DONE: websocket
+	// ActionSetFriendAddRequest 处理加好友请求
+	ActionSetFriendAddRequest = "set_friend_add_request" // DONE: websocket
+	// ActionSetGroupAddRequest 处理加群请求或邀请
+	ActionSetGroupAddRequest = "set_group_add_request" // DONE: websocket
+	// ActionGetGroupMemberList 获取群成员列表
+	ActionGetGroupMemberList = "get_group_member_list" // DONE: websocket
+	// ActionGetGroupMemberInfo 获取群成员信息
+	ActionGetGroupMemberInfo = "get_group_member_info" // DONE: websocket
+	// ActionGetGroupList 获取群列表
+	ActionGetGroupList = "get_group_list" // DONE: websocket
+	// ActionGetLoginInfo 获取登录号信息
+	ActionGetLoginInfo = "get_login_info" // DONE: websocket
 	// ActionGetStatus 获取插件运行状态
 	ActionGetStatus = "get_status" // DONE: http
 )
@@ -32,6 +60,12 @@ type CQResponse struct {
 	Echo    int64       `json:"echo"`
 }
 
+// Failed 动作是否执行失败
+// retcode 为 0 表示成功，为 1 表示已经提交异步处理
+func (res *CQResponse) Failed() bool {
+	return res.Status == "failed" || (res.RetCode != 0 && res.RetCode != 1)
+}
+
 // CQTypeSendGroupMsg SendGroupMsg动作的数据格式
 type CQTypeSendGroupMsg struct {
 	GroupID    int64  `json:"group_id"`
@@ -39,6 +73,46 @@ type CQTypeSendGroupMsg struct {
 	AutoEscape bool   `json:"auto_escape"`
 }
 
+// CQTypeSendGroupMsgSegments 消息段数组格式的 ActionSendGroupMsg 动作数据格式
+// 数组格式的消息不需要转义
+type CQTypeSendGroupMsgSegments struct {
+	GroupID int64            `json:"group_id"`
+	Message []MessageSegment `json:"message"`
+}
+
+// ForwardNode 合并转发中的一条自定义消息
+// Name 和 Uin 为显示的发送者昵称和QQ号
+type ForwardNode struct {
+	Name    string
+	Uin     int64
+	Content []MessageSegment
+}
+
+// forwardNodeSegment node 类型消息段的数据格式
+type forwardNodeSegment struct {
+	Type string `json:"type"`
+	Data struct {
+		Name    string           `json:"name"`
+		Uin     int64            `json:"uin,string"`
+		Content []MessageSegment `json:"content"`
+	} `json:"data"`
+}
+
+// MarshalJSON 转换成 node 类型的消息段
+func (node ForwardNode) MarshalJSON() ([]byte, error) {
+	segment := forwardNodeSegment{Type: "node"}
+	segment.Data.Name = node.Name
+	segment.Data.Uin = node.Uin
+	segment.Data.Content = node.Content
+	return json.Marshal(segment)
+}
+
+// CQTypeSendGroupForwardMsg ActionSendGroupForwardMsg动作的数据格式
+type CQTypeSendGroupForwardMsg struct {
+	GroupID  int64         `json:"group_id"`
+	Messages []ForwardNode `json:"messages"`
+}
+
 // CQTypeSendPrivateMsg ActionSendPrivateMsg动作的数据格式
 type CQTypeSendPrivateMsg struct {
 	UserID     int64  `json:"user_id"`
@@ -46,6 +120,29 @@ type CQTypeSendPrivateMsg struct {
 	AutoEscape bool   `json:"auto_escape"`
 }
 
+// 消息类型
+const (
+	// MessageTypePrivate 私聊消息
+	MessageTypePrivate = "private"
+	// MessageTypeGroup 群消息
+	MessageTypeGroup = "group"
+)
+
+// CQTypeSendMsg ActionSendMsg动作的数据格式
+// 根据 MessageType 只设置 UserID 或 GroupID 其中之一
+type CQTypeSendMsg struct {
+	MessageType string `json:"message_type"`
+	UserID      int64  `json:"user_id,omitempty"`
+	GroupID     int64  `json:"group_id,omitempty"`
+	Message     string `json:"message"`
+	AutoEscape  bool   `json:"auto_escape"`
+}
+
+// CQTypeDeleteMsg ActionDeleteMsg动作的数据格式
+type CQTypeDeleteMsg struct {
+	MessageID int32 `json:"message_id"`
+}
+
 // CQTypeSetGroupKick AActionSetGroupKick动作数据格式
 type CQTypeSetGroupKick struct {
 	GroupID          int64 `json:"group_id"`
@@ -66,6 +163,94 @@ type CQTypeSetGroupWholeBan struct {
 	Enable  bool  `json:"enable"`
 }
 
+// CQTypeSetGroupCard ActionSetGroupCard动作数据格式
+type CQTypeSetGroupCard struct {
+	GroupID int64  `json:"group_id"`
+	UserID  int64  `json:"user_id"`
+	Card    string `json:"card"`
+}
+
+// CQTypeSetGroupName ActionSetGroupName动作数据格式
+type CQTypeSetGroupName struct {
+	GroupID   int64  `json:"group_id"`
+	GroupName string `json:"group_name"`
+}
+
+// CQTypeSetGroupLeave ActionSetGroupLeave动作数据格式
+type CQTypeSetGroupLeave struct {
+	GroupID   int64 `json:"group_id"`
+	IsDismiss bool  `json:"is_dismiss"`
+}
+
+// CQTypeSendLike ActionSendLike动作数据格式
+type CQTypeSendLike struct {
+	UserID int64 `json:"user_id"`
+	Times  int   `json:"times"`
+}
+
+// CQTypeSetFriendAddRequest ActionSetFriendAddRequest动作数据格式
+type CQTypeSetFriendAddRequest struct {
+	Flag    string `json:"flag"`
+	Approve bool   `json:"approve"`
+	Remark  string `json:"remark"`
+}
+
+// CQTypeSetGroupAddRequest ActionSetGroupAddRequest动作数据格式
+type CQTypeSetGroupAddRequest struct {
+	Flag    string `json:"flag"`
+	SubType string `json:"sub_type"`
+	Approve bool   `json:"approve"`
+	Reason  string `json:"reason"`
+}
+
+// CQTypeGetGroupMemberList ActionGetGroupMemberList动作数据格式
+type CQTypeGetGroupMemberList struct {
+	GroupID int64 `json:"group_id"`
+}
+
+// CQTypeGetGroupMemberInfo ActionGetGroupMemberInfo动作数据格式
+type CQTypeGetGroupMemberInfo struct {
+	GroupID int64 `json:"group_id"`
+	UserID  int64 `json:"user_id"`
+	NoCache bool  `json:"no_cache"`
+}
+
+// CQGroupMember 群成员信息
+// ActionGetGroupMemberList的响应数据格式为它的数组
+// 也是ActionGetGroupMemberInfo的响应数据格式
+type CQGroupMember struct {
+	GroupID         int64  `json:"group_id"`
+	UserID          int64  `json:"user_id"`
+	Nickname        string `json:"nickname"`
+	Card            string `json:"card"`
+	Sex             string `json:"sex"`
+	Age             int32  `json:"age"`
+	Area            string `json:"area"`
+	JoinTime        int64  `json:"join_time"`
+	LastSentTime    int64  `json:"last_sent_time"`
+	Level           string `json:"level"`
+	Role            string `json:"role"`
+	Unfriendly      bool   `json:"unfriendly"`
+	Title           string `json:"title"`
+	TitleExpireTime int64  `json:"title_expire_time"`
+	CardChangeable  bool   `json:"card_changeable"`
+}
+
+// CQGroup 群信息
+// ActionGetGroupList的响应数据格式为它的数组
+type CQGroup struct {
+	GroupID        int64  `json:"group_id"`
+	GroupName      string `json:"group_name"`
+	MemberCount    int32  `json:"member_count"`
+	MaxMemberCount int32  `json:"max_member_count"`
+}
+
+// CQTypeGetLoginInfo ActionGetLoginInfo的响应数据格式
+type CQTypeGetLoginInfo struct {
+	UserID   int64  `json:"user_id"`
+	Nickname string `json:"nickname"`
+}
+
 // CQTypeGetStatus ActionGetStatus的响应数据格式
 type CQTypeGetStatus struct {
 	AppInitialized bool `json:"app_initialized"`
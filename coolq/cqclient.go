@@ -1,45 +1,118 @@
 package coolq
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/BurntSushi/toml"
 
 	"github.com/haruno-bot/haruno/clients"
 	"github.com/haruno-bot/haruno/logger"
 )
 
-const timeForWait = 30
+// echo 相关的默认值
+const (
+	// defaultEchoTimeout 等待api响应的默认超时时间
+	defaultEchoTimeout = 30 * time.Second
+	// defaultCleanupInterval 清理超时echo的默认间隔
+	defaultCleanupInterval = 30 * time.Second
+)
+
+// defaultFailoverRetries 切换到备用地址之前默认的重连次数
+const defaultFailoverRetries = 5
 
 const noFilterKey = "__NEVER_SET_UNUSED_KEY__"
 
+// 发送消息时可能返回的错误
+var (
+	// ErrAPINotConnected api服务没有连接，并且没有开启断线时的发送队列
+	ErrAPINotConnected = errors.New("api connection is not available")
+	// ErrSendQueueFull api服务断开期间的发送队列已满
+	ErrSendQueueFull = errors.New("send queue is full")
+	// ErrRateLimited 超过发送速率限制
+	ErrRateLimited = errors.New("send rate limit exceeded")
+)
+
+// maxRawLogLen 日志中记录的原始消息的最大长度
+const maxRawLogLen = 256
+
 // Filter 过滤函数
 type Filter func(*CQEvent) bool
 
 // Handler 处理函数
 type Handler func(*CQEvent)
 
+// HandlerCtx 带 context 的处理函数
+// ctx 在超过 SetHandlerTimeout 设置的时间或者机器人关闭时取消
+type HandlerCtx func(context.Context, *CQEvent)
+
 type pluginEntry struct {
-	keys     []string
-	fitlers  map[string]Filter
-	handlers map[string]Handler
+	keys       []string
+	fitlers    map[string]Filter
+	handlers   map[string]Handler
+	metaEvents bool
+	meta       PluginMeta
+	handlerCnt int
+	enabled    bool
+	priority   int
 }
 
 // cqclient 酷q机器人连接客户端
 // 为了安全起见，暂时不允许在包外额外创建
 type cqclient struct {
-	mu            sync.Mutex
-	token         string
-	apiConn       *clients.WSClient
-	eventConn     *clients.WSClient
-	httpConn      *clients.HTTPClient
-	apiURL        string
-	pluginEntries map[string]pluginEntry
-	echoqueue     map[int64]bool
+	mu               sync.Mutex
+	token            string
+	apiConn          *clients.WSClient
+	eventConn        *clients.WSClient
+	httpConn         *clients.HTTPClient
+	apiURL           string
+	pluginEntries    map[string]pluginEntry
+	pluginOrder      []string
+	plugins          []PluginInterface
+	pluginConfigs    map[string]PluginConfig
+	echoqueue        map[int64]echoWaiter
+	loginInfo        *CQTypeGetLoginInfo
+	lastHeartbeat    time.Time
+	workers          int
+	pool             *workerPool
+	limiter          *rateLimiter
+	queue            *sendQueue
+	dedup            *dedupCache
+	receiveSelf      bool
+	outbox           *outbox
+	universal        int32
+	apiTransport     string
+	transport        apiTransport
+	statusInterval   time.Duration
+	reconnectOffline bool
+	echoTimeout      time.Duration
+	cleanupInterval  time.Duration
+	handlerTimeout   time.Duration
+	dryRun           bool
+	maxMsgLen        int
+	fallbacks        []string
+	upstreams        []string
+	upstream         int
+	failoverRetries  int
+	wsHeaders        http.Header
+	ctx              context.Context
+	cancel           context.CancelFunc
+}
+
+// truncateRaw 截断原始消息用于日志输出
+func truncateRaw(raw []byte) string {
+	if len(raw) > maxRawLogLen {
+		return fmt.Sprintf("%s...(%d bytes)", raw[:maxRawLogLen], len(raw))
+	}
+	return string(raw)
 }
 
 func handleConnect(conn *clients.WSClient) {
@@ -48,11 +121,37 @@ func handleConnect(conn *clients.WSClient) {
 	}
 }
 
+// SetPluginConfigs 设置插件的配置
+// md 为解析配置文件得到的 toml.MetaData，configs 以插件名为key
+// 需要在 RegisterAllPlugins 之前调用
+func (c *cqclient) SetPluginConfigs(md toml.MetaData, configs map[string]toml.Primitive) {
+	c.pluginConfigs = make(map[string]PluginConfig, len(configs))
+	for name, prim := range configs {
+		c.pluginConfigs[name] = PluginConfig{md: &md, prim: prim, ok: true}
+	}
+}
+
 // RegisterAllPlugins 注册所有的插件
 func (c *cqclient) RegisterAllPlugins() {
 	// 1. 先全部执行加载函数
 	loaded := make([]PluginInterface, 0)
+	names := make(map[string]bool)
 	for _, plug := range entries {
+		// 插件以名字区分，同名的插件只加载第一个
+		if names[plug.Name()] {
+			logger.Errorf("Plugin %s can't be loaded, reason:\n another plugin with the same name has been registered", plug.Name())
+			continue
+		}
+		names[plug.Name()] = true
+		if logPlug, ok := plug.(LoggerPlugin); ok {
+			logPlug.SetLogger(logger.Service.Field(plug.Name()))
+		}
+		if confPlug, ok := plug.(ConfigurablePlugin); ok {
+			if err := confPlug.Configure(c.pluginConfigs[plug.Name()]); err != nil {
+				logger.Errorf("Plugin %s can't be configured, reason:\n %v", plug.Name(), err)
+				continue
+			}
+		}
 		err := plug.Load()
 		if err != nil {
 			logger.Errorf("Plugin %s can't be loaded, reason:\n %v", plug.Name(), err)
@@ -67,18 +166,35 @@ func (c *cqclient) RegisterAllPlugins() {
 		pluginName := plug.Name()
 		pluginFilters := plug.Filters()
 		pluginHandlers := plug.Handlers()
+		if ctxPlug, ok := plug.(ContextPlugin); ok {
+			pluginHandlers = c.mergeCtxHandlers(pluginName, pluginHandlers, ctxPlug.HandlersCtx())
+		}
 		hasFilter := make(map[string]bool)
 		entry := pluginEntry{
 			keys:     make([]string, 0),
 			fitlers:  make(map[string]Filter),
 			handlers: make(map[string]Handler),
+			meta: PluginMeta{
+				Name:    pluginName,
+				Version: plug.Version(),
+				Author:  plug.Author(),
+			},
+			handlerCnt: len(pluginHandlers),
+			enabled:    true,
+		}
+		if metaPlug, ok := plug.(MetaEventPlugin); ok {
+			entry.metaEvents = metaPlug.AcceptMetaEvent()
 		}
+		if priorityPlug, ok := plug.(PriorityPlugin); ok {
+			entry.priority = priorityPlug.Priority()
+		}
+		plugLogger := logger.Service.Field(pluginName)
 		noFilterHanlers := make([]Handler, 0)
 		// 对应filter的key寻找相应的handler， 没有的话则给出警告
 		for key, filter := range pluginFilters {
 			handler := pluginHandlers[key]
 			if handler == nil {
-				logger.Logger.Warnf("插件 %s 中存在没有使用的key: %s\n", pluginName, key)
+				plugLogger.Errorf("存在没有使用的key: %s，它没有对应的handler", key)
 				continue
 			}
 			hasFilter[key] = true
@@ -86,11 +202,17 @@ func (c *cqclient) RegisterAllPlugins() {
 			entry.fitlers[key] = filter
 			entry.handlers[key] = handler
 		}
+		// 同一个插件内的 filter 按key排序执行
+		sort.Strings(entry.keys)
 		for key, handler := range pluginHandlers {
 			if !hasFilter[key] {
 				noFilterHanlers = append(noFilterHanlers, handler)
 			}
 		}
+		// 没有filter的handler会处理所有的事件
+		if len(noFilterHanlers) > 0 {
+			plugLogger.Infof("%d 个handler没有对应的filter，会处理所有的事件", len(noFilterHanlers))
+		}
 		// 最后注册无key的handler
 		entry.handlers[noFilterKey] = func(event *CQEvent) {
 			for _, hanldeFunc := range noFilterHanlers {
@@ -99,30 +221,408 @@ func (c *cqclient) RegisterAllPlugins() {
 		}
 		c.pluginEntries[pluginName] = entry
 	}
+	c.plugins = loaded
+	// 按优先级排好插件处理事件的顺序
+	c.pluginOrder = make([]string, 0, len(c.pluginEntries))
+	for name := range c.pluginEntries {
+		c.pluginOrder = append(c.pluginOrder, name)
+	}
+	sort.Slice(c.pluginOrder, func(i, j int) bool {
+		a := c.pluginEntries[c.pluginOrder[i]]
+		b := c.pluginEntries[c.pluginOrder[j]]
+		if a.priority != b.priority {
+			return a.priority < b.priority
+		}
+		return a.meta.Name < b.meta.Name
+	})
 	// 3. 触发所有插件的onload事件
 	for _, plug := range loaded {
 		go plug.Loaded()
 	}
 }
 
-func (c *cqclient) deqEcho(echo int64) {
+// mergeCtxHandlers 把带 context 的处理函数包装成 Handler 合并到 handlers 中
+// 同一个key同时存在时使用带 context 的处理函数，并和没有使用的key一样记录错误日志
+func (c *cqclient) mergeCtxHandlers(pluginName string, handlers map[string]Handler, ctxHandlers map[string]HandlerCtx) map[string]Handler {
+	merged := make(map[string]Handler, len(handlers)+len(ctxHandlers))
+	for key, handler := range handlers {
+		merged[key] = handler
+	}
+	for key, handler := range ctxHandlers {
+		if _, ok := merged[key]; ok {
+			logger.Service.Field(pluginName).Errorf("key: %s 同时存在 Handler 和 HandlerCtx，使用 HandlerCtx", key)
+		}
+		handler := handler
+		merged[key] = func(event *CQEvent) {
+			ctx, cancel := c.handlerContext()
+			defer cancel()
+			handler(ctx, event)
+		}
+	}
+	return merged
+}
+
+// handlerContext 生成一次 HandlerCtx 调用使用的 context
+func (c *cqclient) handlerContext() (context.Context, context.CancelFunc) {
+	parent := c.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	if c.handlerTimeout > 0 {
+		return context.WithTimeout(parent, c.handlerTimeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// echoWaiter 等待响应的echo
+type echoWaiter struct {
+	ch     chan *CQResponse
+	sentAt time.Time
+}
+
+// echoSeq 用来生成echo，使用atomic操作
+var echoSeq int64
+
+// nextEcho 生成一个新的echo，同一时间发送的消息也不会重复
+func nextEcho() int64 {
+	return atomic.AddInt64(&echoSeq, 1)
+}
+
+// enqEcho 登记一个echo，返回接收对应响应的管道
+func (c *cqclient) enqEcho(echo int64) chan *CQResponse {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	ch := make(chan *CQResponse, 1)
+	c.echoqueue[echo] = echoWaiter{ch: ch, sentAt: time.Now()}
+	return ch
+}
+
+// PluginsMeta 获取所有已注册插件的信息，按插件名排序
+func (c *cqclient) PluginsMeta() []PluginMeta {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	metas := make([]PluginMeta, 0, len(c.pluginEntries))
+	for _, entry := range c.pluginEntries {
+		metas = append(metas, entry.meta)
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].Name < metas[j].Name
+	})
+	return metas
+}
+
+// PluginNames 获取所有已注册插件的名字，按插件名排序
+func (c *cqclient) PluginNames() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := make([]string, 0, len(c.pluginEntries))
+	for name := range c.pluginEntries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PluginFilterKeys 获取插件注册的filter的key，按key排序
+// 插件没有注册时返回 nil
+func (c *cqclient) PluginFilterKeys(name string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.pluginEntries[name]
+	if !ok {
+		return nil
+	}
+	keys := make([]string, len(entry.keys))
+	copy(keys, entry.keys)
+	return keys
+}
+
+// PluginsStatus 获取所有已注册插件的运行状态，按插件名排序
+func (c *cqclient) PluginsStatus() []PluginStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	statuses := make([]PluginStatus, 0, len(c.pluginEntries))
+	for _, entry := range c.pluginEntries {
+		statuses = append(statuses, PluginStatus{
+			PluginMeta: entry.meta,
+			Filters:    len(entry.keys),
+			Keys:       append([]string{}, entry.keys...),
+			Handlers:   entry.handlerCnt,
+			Enabled:    entry.enabled,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// SetPluginEnabled 启用或者禁用插件，对之后的上报事件立即生效
+// 插件不存在时返回 false
+func (c *cqclient) SetPluginEnabled(name string, enabled bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.pluginEntries[name]
+	if !ok {
+		return false
+	}
+	entry.enabled = enabled
+	c.pluginEntries[name] = entry
+	return true
+}
+
+// UnloadAllPlugins 卸载所有的插件
+// 按加载的顺序调用实现了 UnloadPlugin 接口的插件的 Unload 方法
+func (c *cqclient) UnloadAllPlugins() {
+	c.mu.Lock()
+	loaded := c.plugins
+	c.mu.Unlock()
+	for _, plug := range loaded {
+		unloader, ok := plug.(UnloadPlugin)
+		if !ok {
+			continue
+		}
+		if err := unloader.Unload(); err != nil {
+			logger.Errorf("Plugin %s can't be unloaded, reason:\n %v", plug.Name(), err)
+		}
+	}
+}
+
+// deqEcho 移除一个echo，返回对应的管道(不存在时为nil)
+func (c *cqclient) deqEcho(echo int64) chan *CQResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	waiter := c.echoqueue[echo]
 	delete(c.echoqueue, echo)
+	return waiter.ch
+}
+
+// dispatch 把上报事件分发给所有插件
+// 插件按优先级的顺序依次处理同一个事件，前一个插件处理完才会交给下一个
+// 任何handler调用了 event.StopPropagation() 之后，后面的插件都不会再收到这个事件
+// 每个插件总是在同一个worker上执行，保证同一个插件处理事件的顺序
+func (c *cqclient) dispatch(event *CQEvent) {
+	// 机器人自己发送的消息可能也会被上报，默认跳过，避免插件互相回复形成死循环
+	if event.PostType == PostTypeMessage && c.isSelfMessage(event) {
+		return
+	}
+	// 重连后可能收到重复上报的消息，跳过已经处理过的
+	if event.PostType == PostTypeMessage && event.MessageID != 0 {
+		if c.dedup.seenBefore(dedupKey{event.MessageID, event.Time}) {
+			logger.Logger.Debugf("message %d has been handled, duplicated event is skipped\n", event.MessageID)
+			return
+		}
+	}
+	isMetaEvent := event.PostType == PostTypeMetaEvent
+	if isMetaEvent && event.MetaEventType == MetaEventTypeHeartbeat {
+		c.mu.Lock()
+		c.lastHeartbeat = time.Now()
+		c.mu.Unlock()
+	}
+	c.mu.Lock()
+	entries := make([]pluginEntry, 0, len(c.pluginOrder))
+	for _, name := range c.pluginOrder {
+		entry := c.pluginEntries[name]
+		if !entry.enabled {
+			continue
+		}
+		// 元事件只分发给明确需要的插件
+		if isMetaEvent && !entry.metaEvents {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	c.mu.Unlock()
+	if len(entries) == 0 {
+		return
+	}
+	// 在读取上报的协程中调用，插件处理太慢导致队列已满时直接丢弃，不能阻塞读取
+	name := entries[0].meta.Name
+	switch err := c.pool.submit(name, c.dispatchJob(entries, event)); err {
+	case errPoolStopped:
+		logger.Logger.Warnf("haruno is shutting down, event %s is dropped\n", event.PostType)
+	case errQueueFull:
+		logger.Logger.Warnf("worker queue of plugin %s is full, event %s is dropped (%d dropped in total)\n", name, event.PostType, c.pool.droppedCount())
+	}
+}
+
+// dispatchJob 生成让 entries[0] 处理事件的任务
+// 处理结束后把事件交给下一个插件
+func (c *cqclient) dispatchJob(entries []pluginEntry, event *CQEvent) func() {
+	entry := entries[0]
+	return func() {
+		// 即使handler出现panic也继续交给下一个插件
+		defer func() {
+			if event.IsPropagationStopped() || len(entries) == 1 {
+				return
+			}
+			next := entries[1:]
+			name := next[0].meta.Name
+			// 丢弃之后后面所有的插件都不会收到这个事件
+			if err := c.pool.chain(name, c.dispatchJob(next, event)); err != nil {
+				logger.Errorf("event %s can't be handed over to plugin %s, %d plugins miss it: %v", event.PostType, name, len(next), err)
+			}
+		}()
+		// 先处理没有key的回调
+		entry.handlers[noFilterKey](event)
+		// 再依次执行所有的 filter 和 handler 对
+		for _, key := range entry.keys {
+			if entry.fitlers[key](event) {
+				entry.handlers[key](event)
+			}
+		}
+	}
+}
+
+// SetSendRateLimit 设置发送消息的速率限制(条/秒)
+// rate <= 0 时不限制，可以在运行时调用
+func (c *cqclient) SetSendRateLimit(rate float64) {
+	c.limiter.SetRate(rate)
+}
+
+// SetSendQueueSize 设置api服务断开时发送队列的最大长度
+// size == 0 时使用默认值 100，size < 0 时断开期间的消息直接丢弃
+func (c *cqclient) SetSendQueueSize(size int) {
+	c.queue.setSize(size)
+}
+
+// SetDedupSize 设置用来跳过重复上报记住的最近消息数
+// size == 0 时使用默认值 256，size < 0 时不去重
+func (c *cqclient) SetDedupSize(size int) {
+	c.dedup.setSize(size)
+}
+
+// SetReceiveSelf 设置是否把机器人自己发送的消息分发给插件
+// 默认不分发
+func (c *cqclient) SetReceiveSelf(receive bool) {
+	c.mu.Lock()
+	c.receiveSelf = receive
+	c.mu.Unlock()
+}
+
+// isSelfMessage 检查是否是需要跳过的机器人自己发送的消息
+// 还没有获取到登录号信息时使用上报中的 self_id
+func (c *cqclient) isSelfMessage(event *CQEvent) bool {
+	c.mu.Lock()
+	receive := c.receiveSelf
+	c.mu.Unlock()
+	if receive {
+		return false
+	}
+	selfID := c.SelfID()
+	if selfID == 0 {
+		selfID = event.SelfID
+	}
+	return selfID != 0 && event.UserID == selfID
+}
+
+// SetSendQueueTTL 设置消息在发送队列中的有效期
+// ttl <= 0 时使用默认值 60s
+func (c *cqclient) SetSendQueueTTL(ttl time.Duration) {
+	c.queue.setTTL(ttl)
+}
+
+// SetAPITransport 设置发送api请求的方式
+// 可选 APITransportWebsocket 和 APITransportHTTP，默认为 websocket
+// 需要在 Initialize 之前调用
+func (c *cqclient) SetAPITransport(transport string) {
+	c.apiTransport = transport
+}
+
+// SetWriteTimeout 设置websocket连接的写超时
+// 需要在 Connect 之前调用，timeout <= 0 时使用默认值 10s
+func (c *cqclient) SetWriteTimeout(timeout time.Duration) {
+	c.apiConn.WriteTimeout = timeout
+	c.eventConn.WriteTimeout = timeout
+}
+
+// SetHandlerTimeout 设置每次调用 HandlerCtx 的超时时间
+// 需要在 RegisterAllPlugins 之前调用，timeout <= 0 时不限制
+func (c *cqclient) SetHandlerTimeout(timeout time.Duration) {
+	c.handlerTimeout = timeout
+}
+
+// SetEchoTimeout 设置等待api响应的超时时间
+// 需要在 Initialize 之前调用，timeout <= 0 时使用默认值 30s
+func (c *cqclient) SetEchoTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = defaultEchoTimeout
+	}
+	c.echoTimeout = timeout
+}
+
+// SetCleanupInterval 设置清理超时echo的间隔
+// 需要在 Initialize 之前调用，interval <= 0 时使用默认值 30s
+func (c *cqclient) SetCleanupInterval(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultCleanupInterval
+	}
+	c.cleanupInterval = interval
+}
+
+// SetHandshakeTimeout 设置连接酷q websocket服务的超时时间
+// 需要在 Connect 之前调用，timeout <= 0 时使用默认值 10s
+func (c *cqclient) SetHandshakeTimeout(timeout time.Duration) {
+	c.apiConn.HandshakeTimeout = timeout
+	c.eventConn.HandshakeTimeout = timeout
+}
+
+// SetInsecureSkipVerify 设置使用 wss:// 连接时是否跳过证书校验，用于自签名证书
+// 需要在 Connect 之前调用
+func (c *cqclient) SetInsecureSkipVerify(skip bool) {
+	if !skip {
+		return
+	}
+	c.apiConn.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	c.eventConn.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+}
+
+// SetProxy 设置连接酷q websocket服务使用的代理
+// 支持 http 和 socks5 代理，为空时使用环境变量中的代理
+// 需要在 Connect 之前调用
+func (c *cqclient) SetProxy(proxy string) {
+	c.apiConn.Proxy = proxy
+	c.eventConn.Proxy = proxy
+}
+
+// SetWorkerPoolSize 设置处理上报事件的协程池大小
+// 需要在 Initialize 之前调用，size <= 0 时使用 CPU 核数
+func (c *cqclient) SetWorkerPoolSize(size int) {
+	c.workers = size
 }
 
 // Initialize 初始化客户端
 // token 酷q机器人的access token
 func (c *cqclient) Initialize(token string) {
 	c.token = token
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.pool = newWorkerPool(c.workers)
 	c.httpConn = clients.NewHTTPClient()
-	c.httpConn.Header.Set("Authorization", fmt.Sprintf("Token %s", c.token))
+	c.httpConn.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token))
+	if c.dryRun {
+		logger.Logger.Warnln("dry run mode is on, api requests will only be logged")
+		c.transport = dryRunTransport{}
+	} else if c.apiTransport == APITransportHTTP {
+		c.transport = httpTransport{c}
+	} else {
+		c.transport = wsTransport{c}
+	}
 
 	c.apiConn.Name = "coolq api conn"
 	c.eventConn.Name = "coolq event conn"
 	// 注册连接事件回调
-	c.apiConn.OnConnect = handleConnect
+	c.apiConn.OnConnect = func(conn *clients.WSClient) {
+		handleConnect(conn)
+		go c.flushQueue()
+	}
 	c.eventConn.OnConnect = handleConnect
+	// 注册断开连接事件回调
+	c.apiConn.OnDisconnect = func() {
+		logger.Field(c.apiConn.Name).Error("api服务已断开")
+	}
+	c.eventConn.OnDisconnect = func() {
+		logger.Field(c.eventConn.Name).Error("event服务已断开")
+	}
 	// 注册错误事件回调
 	c.apiConn.OnError = func(err error) {
 		logger.Field(c.apiConn.Name).Error(err)
@@ -132,16 +632,23 @@ func (c *cqclient) Initialize(token string) {
 	}
 	// 注册消息事件回调
 	c.apiConn.OnMessage = func(raw []byte) {
+		// 反向websocket的universal连接上同时有api响应和上报事件
+		if c.isUniversal() && isEventPayload(raw) {
+			c.eventConn.OnMessage(raw)
+			return
+		}
 		msg := new(CQResponse)
 		err := json.Unmarshal(raw, msg)
 		if err != nil {
-			logger.Field(c.apiConn.Name).Errorf("on message error %v", err)
+			logger.Field(c.apiConn.Name).Errorf("on message error %v, raw: %s", err, truncateRaw(raw))
 			return
 		}
-		// echo队列 - 确定发送消息是否超时
-		echo := msg.Echo
-		if c.echoqueue[echo] {
-			c.deqEcho(echo)
+		if msg.Failed() {
+			logger.Field(c.apiConn.Name).Errorf("(echo) id = %d action failed, status = %s, retcode = %d", msg.Echo, msg.Status, msg.RetCode)
+		}
+		// echo队列 - 把响应交给等待的调用方
+		if ch := c.deqEcho(msg.Echo); ch != nil {
+			ch <- msg
 		}
 	}
 	// 注册上报事件回调
@@ -149,100 +656,514 @@ func (c *cqclient) Initialize(token string) {
 		event := new(CQEvent)
 		err := json.Unmarshal(raw, event)
 		if err != nil {
-			logger.Field(c.eventConn.Name).Errorf("on message error %v", err)
+			logger.Field(c.eventConn.Name).Errorf("on message error %v, raw: %s", err, truncateRaw(raw))
 			return
 		}
-		for name, entry := range c.pluginEntries {
-			// 先异步处理没有key的回调
-			go entry.handlers[noFilterKey](event)
-			// 一次异步执行所有的 filter 和 handler 对
-			for _, key := range entry.keys {
-				go func(key string, name string) {
-					if c.pluginEntries[name].fitlers[key](event) {
-						c.pluginEntries[name].handlers[key](event)
-					}
-				}(key, name)
-			}
-		}
+		c.dispatch(event)
 	}
 
-	// 定时清理echo队列 (30s)
+	if c.statusInterval > 0 {
+		go c.pollStatus()
+	}
+
+	// 定时清理echo队列，直到客户端关闭
 	go func() {
-		ticker := time.NewTicker(timeForWait * time.Second)
+		ticker := time.NewTicker(c.cleanupInterval)
 		defer ticker.Stop()
 		for {
 			select {
+			case <-c.ctx.Done():
+				return
 			case <-ticker.C:
-				now := time.Now().Unix()
-				for echo, state := range c.echoqueue {
-					// 对于超过30s未响应的给出提示
-					if state && now-echo > timeForWait {
-						logger.Errorf("(echo) id = %d response time out (30s)", echo)
-						c.deqEcho(echo)
+				now := time.Now()
+				c.mu.Lock()
+				for echo, waiter := range c.echoqueue {
+					// 对于超时未响应的给出提示
+					if now.Sub(waiter.sentAt) > c.echoTimeout {
+						logger.Errorf("(echo) id = %d response time out (%v)", echo, c.echoTimeout)
+						delete(c.echoqueue, echo)
 					}
 				}
+				c.mu.Unlock()
 			}
 		}
 	}()
 }
 
+// Drain 停止分发新的上报事件，并等待正在处理的事件处理完
+// 超过 timeout 时给出还没有处理完的任务数
+// 正在执行的 HandlerCtx 的 ctx 会被取消
+func (c *cqclient) Drain(timeout time.Duration) {
+	if c.pool == nil {
+		return
+	}
+	c.cancel()
+	if pending := c.pool.drain(timeout); pending > 0 {
+		logger.Logger.Warnf("%d plugin handler jobs are still running after %v\n", pending, timeout)
+	}
+}
+
+// Close 关闭和酷q的连接，之后不会再重连
+// 同时停止处理事件的worker和后台的定时任务，应该在 Drain 之后调用
+func (c *cqclient) Close() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	if c.pool != nil {
+		c.pool.stop()
+	}
+	c.apiConn.Close()
+	c.eventConn.Close()
+}
+
+// LastHeartbeat 最近一次收到心跳的时间
+// 没有收到过心跳时为零值
+func (c *cqclient) LastHeartbeat() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastHeartbeat
+}
+
 // Connect 连接远程酷q api服务
 // wsURL 形如 ws://127.0.0.1:8080, wss://127.0.0.1:8080之类的url 用于建立ws连接
 // httpURL 形如 http://127.0.0.1:8080之类的url 用户建立http”连接“
+// 设置了备用地址时，event服务重连失败后会依次切换到下一个地址
 func (c *cqclient) Connect(wsURL, httpURL string) {
 	headers := make(http.Header)
 	headers.Add("Authorization", fmt.Sprintf("Token %s", c.token))
-	// 连接api服务和事件服务
-	c.apiConn.Dial(fmt.Sprintf("%s/api", wsURL), headers)
-	c.eventConn.Dial(fmt.Sprintf("%s/event", wsURL), headers)
+	c.mu.Lock()
+	c.upstreams = uniqueURLs(append([]string{wsURL}, c.fallbacks...))
+	c.upstream = 0
+	c.wsHeaders = headers
+	if len(c.upstreams) > 1 {
+		c.eventConn.MaxRetries = c.failoverRetries
+		c.eventConn.OnGiveUp = c.failover
+	}
+	c.mu.Unlock()
+	c.setAPIURL(httpURL)
+	// 连接api服务和事件服务，使用 http api 或者 dry run 时不需要 websocket api 连接
+	if c.needAPIConn() {
+		if err := c.apiConn.DialWithRetry(fmt.Sprintf("%s/api", wsURL), headers); err != nil {
+			logger.Errorf("%v", err)
+		}
+	}
+	if err := c.eventConn.DialWithRetry(fmt.Sprintf("%s/event", wsURL), headers); err != nil {
+		logger.Errorf("%v", err)
+	}
+}
+
+// setAPIURL 设置酷q http api的地址
+// 使用 http api 发送时，设置之后发送之前缓存的消息
+func (c *cqclient) setAPIURL(httpURL string) {
+	c.mu.Lock()
 	c.apiURL = httpURL
+	_, isHTTP := c.transport.(httpTransport)
+	c.mu.Unlock()
+	if isHTTP && httpURL != "" {
+		go c.flushQueue()
+	}
+}
+
+// httpAPIURL 酷q http api的地址
+func (c *cqclient) httpAPIURL() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.apiURL
+}
+
+// needAPIConn 是否需要连接 websocket api 服务
+func (c *cqclient) needAPIConn() bool {
+	return !c.dryRun && c.apiTransport != APITransportHTTP
+}
+
+// SetMaxMessageLength 设置 SendGroupMsg 和 SendPrivateMsg 单条消息的最大字符数
+// 超过的消息会被切分成多条发送，cq码不会被切开，n <= 0 时不切分
+func (c *cqclient) SetMaxMessageLength(n int) {
+	c.mu.Lock()
+	c.maxMsgLen = n
+	c.mu.Unlock()
+}
+
+// maxMessageLength 单条消息的最大字符数，可能在重新加载配置时被修改
+func (c *cqclient) maxMessageLength() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.maxMsgLen
+}
+
+// SetDryRun 设置 dry run 模式
+// 开启后所有的api请求都只记录日志而不会真正发送，IsAPIOk 总是返回 true
+// 需要在 Initialize 之前调用
+func (c *cqclient) SetDryRun(dryRun bool) {
+	c.dryRun = dryRun
+}
+
+// SetFallbackURLs 设置备用的酷q websocket服务地址
+// 需要在 Connect 之前调用，和主地址重复的地址会被忽略
+func (c *cqclient) SetFallbackURLs(urls []string) {
+	c.fallbacks = urls
+}
+
+// uniqueURLs 去掉重复的和空的地址，保持原来的顺序
+func uniqueURLs(urls []string) []string {
+	seen := make(map[string]bool, len(urls))
+	unique := make([]string, 0, len(urls))
+	for _, u := range urls {
+		if u == "" || seen[u] {
+			continue
+		}
+		seen[u] = true
+		unique = append(unique, u)
+	}
+	return unique
+}
+
+// SetFailoverRetries 设置切换到备用地址之前重连的次数
+// 需要在 Connect 之前调用，retries <= 0 时使用默认值 5
+func (c *cqclient) SetFailoverRetries(retries int) {
+	if retries <= 0 {
+		retries = defaultFailoverRetries
+	}
+	c.failoverRetries = retries
+}
+
+// Upstream 当前使用的酷q websocket服务地址
+func (c *cqclient) Upstream() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.upstreams) == 0 {
+		return ""
+	}
+	return c.upstreams[c.upstream]
+}
+
+// failover event服务重连失败时切换到下一个地址
+// api服务也会断开并连接到新的地址
+func (c *cqclient) failover(conn *clients.WSClient) {
+	c.mu.Lock()
+	c.upstream = (c.upstream + 1) % len(c.upstreams)
+	wsURL := c.upstreams[c.upstream]
+	headers := c.wsHeaders
+	c.mu.Unlock()
+	logger.Logger.Warnf("switch to cqhttp upstream %s\n", wsURL)
+	if c.needAPIConn() {
+		c.apiConn.Retarget(fmt.Sprintf("%s/api", wsURL))
+	}
+	if err := conn.DialWithRetry(fmt.Sprintf("%s/event", wsURL), headers); err != nil {
+		logger.Errorf("%v", err)
+	}
 }
 
 // IsAPIOk api服务是否可用
 func (c *cqclient) IsAPIOk() bool {
-	return c.apiConn.IsConnected()
+	if c.transport == nil {
+		return false
+	}
+	return c.transport.available()
 }
 
 // IsEventOk event服务是否可用
 func (c *cqclient) IsEventOk() bool {
+	if c.isUniversal() {
+		return c.apiConn.IsConnected()
+	}
 	return c.eventConn.IsConnected()
 }
 
 // APISendJSON 发送api json格式的数据
+// data 需要是 CQWSMessage 的格式，和其他api请求一样通过当前的api发送方式发送，不等待响应
 func (c *cqclient) APISendJSON(data interface{}) {
-	if !c.IsAPIOk() {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		logger.Errorf("cqclient APISendJSON error: %v", err)
 		return
 	}
-	msg, _ := json.Marshal(data)
-	c.apiConn.Send(websocket.TextMessage, msg)
+	payload := new(CQWSMessage)
+	if err := json.Unmarshal(raw, payload); err != nil {
+		logger.Errorf("cqclient APISendJSON error: %v", err)
+		return
+	}
+	if payload.Echo == 0 {
+		payload.Echo = nextEcho()
+	}
+	if _, err := c.apiSend(payload); err != nil {
+		logger.Errorf("cqclient APISendJSON %s error: %v", payload.Action, err)
+	}
+}
+
+// apiSend 通过当前的api发送方式发送api消息
+// 返回的管道会在收到对应的响应时被写入
+func (c *cqclient) apiSend(payload *CQWSMessage) (chan *CQResponse, error) {
+	if !c.IsAPIOk() {
+		return nil, ErrAPINotConnected
+	}
+	return c.transport.send(payload)
+}
+
+// apiCall 发送api消息并等待响应
+// 超过 echoTimeout 未响应则返回超时错误
+func (c *cqclient) apiCall(payload *CQWSMessage) (*CQResponse, error) {
+	ch, err := c.apiSend(payload)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case res := <-ch:
+		if res.RetCode != 0 {
+			return res, fmt.Errorf("action %s failed, retcode = %d", payload.Action, res.RetCode)
+		}
+		return res, nil
+	case <-time.After(c.echoTimeout):
+		c.deqEcho(payload.Echo)
+		return nil, fmt.Errorf("(echo) id = %d response time out (%v)", payload.Echo, c.echoTimeout)
+	}
+}
+
+// decodeData 把响应的data字段解析到v
+func decodeData(res *CQResponse, v interface{}) error {
+	raw, err := json.Marshal(res.Data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// sendLimited 经过限流之后发送消息
+// wait 为 true 时等待令牌，否则没有令牌时直接丢弃并给出警告
+func (c *cqclient) sendLimited(payload *CQWSMessage, wait bool) error {
+	if wait {
+		c.limiter.Wait()
+	} else if !c.limiter.Allow() {
+		logger.Logger.Warnf("send rate limit exceeded, action %s is dropped\n", payload.Action)
+		return ErrRateLimited
+	}
+	err := c.post(payload)
+	if err == nil {
+		c.outbox.record(payload)
+	}
+	return err
+}
+
+// post 发送不需要等待响应的api消息
+// api服务不可用时先放入发送队列，连接恢复后再发送
+func (c *cqclient) post(payload *CQWSMessage) error {
+	if c.IsAPIOk() {
+		_, err := c.apiSend(payload)
+		return err
+	}
+	if c.queue.disabled() {
+		logger.Logger.Warnf("api connection is not available, action %s is dropped\n", payload.Action)
+		return ErrAPINotConnected
+	}
+	if !c.queue.push(payload) {
+		logger.Logger.Warnf("send queue is full, action %s is dropped\n", payload.Action)
+		return ErrSendQueueFull
+	}
+	return nil
+}
+
+// flushQueue 按顺序发送队列中缓存的消息
+// 超过有效期的消息会被丢弃
+func (c *cqclient) flushQueue() {
+	items, ttl := c.queue.drain()
+	for i, item := range items {
+		if item.expired(ttl) {
+			logger.Logger.Warnf("action %s has been queued over %v, dropped\n", item.payload.Action, ttl)
+			continue
+		}
+		// 重新生成echo，避免和缓存期间发送的消息混淆
+		item.payload.Echo = nextEcho()
+		if _, err := c.apiSend(item.payload); err != nil {
+			// 连接又断开了，剩下的消息等下次连接再发
+			c.queue.requeue(items[i:])
+			return
+		}
+	}
+}
+
+func newSendGroupMsg(groupID int64, message string) *CQWSMessage {
+	return &CQWSMessage{
+		Action: ActionSendGroupMsg,
+		Params: CQTypeSendGroupMsg{
+			GroupID: groupID,
+			Message: message,
+		},
+		Echo: nextEcho(),
+	}
 }
 
 // SendGroupMsg 发送群消息
+// 超过发送速率限制时等待，发送失败时记录错误日志
 // websocket 接口
 func (c *cqclient) SendGroupMsg(groupID int64, message string) {
+	if err := c.SendGroupMsgErr(groupID, message); err != nil {
+		logger.Errorf("send message to group %d failed: %v", groupID, err)
+	}
+}
+
+// SendGroupMsgErr 发送群消息并返回发送时的错误
+// api服务断开期间消息会进入发送队列，这时不返回错误
+// 设置了 SetMaxMessageLength 时过长的消息会被切分成多条依次发送，某一条失败时不再发送剩下的
+// 不等待响应，需要确认消息发送成功时使用 SendGroupMsgSync
+// websocket 接口
+func (c *cqclient) SendGroupMsgErr(groupID int64, message string) error {
+	for _, chunk := range splitMessage(message, c.maxMessageLength()) {
+		if err := c.sendLimited(newSendGroupMsg(groupID, chunk), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendGroupMsgNoWait 发送群消息
+// 超过发送速率限制时直接丢弃
+// websocket 接口
+func (c *cqclient) SendGroupMsgNoWait(groupID int64, message string) {
+	c.sendLimited(newSendGroupMsg(groupID, message), false)
+}
+
+// ReplyGroupAt 回复群消息并@发送者
+// message 作为纯文本发送，会被转义，需要cq码时请用 SendGroupMsg
+// event 不是群消息时什么都不做，超过发送速率限制时等待
+// websocket 接口
+func (c *cqclient) ReplyGroupAt(event *CQEvent, message string) {
+	if !event.IsGroupMessage() {
+		return
+	}
+	c.SendGroupMsg(event.GroupID, fmt.Sprintf("%s %s", CQAt(event.UserID), Escape(message)))
+}
+
+// SendGroupMsgSegments 用消息段数组发送群消息
+// 消息段中的文本不需要转义，超过发送速率限制时等待
+// websocket 接口
+func (c *cqclient) SendGroupMsgSegments(groupID int64, segments []MessageSegment) {
 	payload := &CQWSMessage{
 		Action: ActionSendGroupMsg,
-		Params: CQTypeSendGroupMsg{
+		Params: CQTypeSendGroupMsgSegments{
 			GroupID: groupID,
-			Message: message,
+			Message: segments,
 		},
-		Echo: time.Now().Unix(),
+		Echo: nextEcho(),
 	}
-	c.APISendJSON(payload)
+	c.sendLimited(payload, true)
 }
 
-// SendPrivateMsg 发送私聊消息
+// SendGroupForwardMsg 发送合并转发消息到群
+// 适合把搜索结果、长回复等多条消息合并成一条发送，超过发送速率限制时等待
 // websocket 接口
-func (c *cqclient) SendPrivateMsg(userID int64, message string) {
+func (c *cqclient) SendGroupForwardMsg(groupID int64, nodes []ForwardNode) {
 	payload := &CQWSMessage{
+		Action: ActionSendGroupForwardMsg,
+		Params: CQTypeSendGroupForwardMsg{
+			GroupID:  groupID,
+			Messages: nodes,
+		},
+		Echo: nextEcho(),
+	}
+	if err := c.sendLimited(payload, true); err != nil {
+		logger.Errorf("send forward message to group %d failed: %v", groupID, err)
+	}
+}
+
+// SendGroupMsgSync 发送群消息并等待响应
+// 返回发送的消息的 message_id
+// websocket 接口
+func (c *cqclient) SendGroupMsgSync(groupID int64, message string) (int32, error) {
+	c.limiter.Wait()
+	payload := newSendGroupMsg(groupID, message)
+	res, err := c.apiCall(payload)
+	if err != nil {
+		return 0, err
+	}
+	c.outbox.record(payload)
+	data, ok := res.Data.(map[string]interface{})
+	if !ok {
+		return 0, errors.New("invalid response data of send_group_msg")
+	}
+	messageID, ok := data["message_id"].(float64)
+	if !ok {
+		return 0, errors.New("invalid message_id in response data of send_group_msg")
+	}
+	return int32(messageID), nil
+}
+
+func newSendPrivateMsg(userID int64, message string) *CQWSMessage {
+	return &CQWSMessage{
 		Action: ActionSendPrivateMsg,
 		Params: CQTypeSendPrivateMsg{
 			UserID:  userID,
 			Message: message,
 		},
-		Echo: time.Now().Unix(),
+		Echo: nextEcho(),
 	}
-	c.APISendJSON(payload)
+}
+
+// SendPrivateMsg 发送私聊消息
+// 超过发送速率限制时等待，发送失败时记录错误日志
+// websocket 接口
+func (c *cqclient) SendPrivateMsg(userID int64, message string) {
+	if err := c.SendPrivateMsgErr(userID, message); err != nil {
+		logger.Errorf("send message to user %d failed: %v", userID, err)
+	}
+}
+
+// SendPrivateMsgErr 发送私聊消息并返回发送时的错误
+// api服务断开期间消息会进入发送队列，这时不返回错误
+// 过长的消息和 SendGroupMsgErr 一样会被切分
+// websocket 接口
+func (c *cqclient) SendPrivateMsgErr(userID int64, message string) error {
+	for _, chunk := range splitMessage(message, c.maxMessageLength()) {
+		if err := c.sendLimited(newSendPrivateMsg(userID, chunk), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendPrivateMsgNoWait 发送私聊消息
+// 超过发送速率限制时直接丢弃
+// websocket 接口
+func (c *cqclient) SendPrivateMsgNoWait(userID int64, message string) {
+	c.sendLimited(newSendPrivateMsg(userID, message), false)
+}
+
+// SendMsg 发送消息
+// messageType 消息类型，只能是 "group" 或 "private"
+// targetID 群号或者QQ号，由 messageType 决定
+// websocket 接口
+func (c *cqclient) SendMsg(messageType string, targetID int64, message string) {
+	params := CQTypeSendMsg{
+		MessageType: messageType,
+		Message:     message,
+	}
+	switch messageType {
+	case MessageTypeGroup:
+		params.GroupID = targetID
+	case MessageTypePrivate:
+		params.UserID = targetID
+	default:
+		logger.Errorf("cqclient SendMsg error: unknown message type \"%s\"", messageType)
+		return
+	}
+	payload := &CQWSMessage{
+		Action: ActionSendMsg,
+		Params: params,
+		Echo:   nextEcho(),
+	}
+	c.sendLimited(payload, true)
+}
+
+// DeleteMsg 撤回消息
+// websocket 接口
+func (c *cqclient) DeleteMsg(messageID int32) {
+	payload := &CQWSMessage{
+		Action: ActionDeleteMsg,
+		Params: CQTypeDeleteMsg{
+			MessageID: messageID,
+		},
+		Echo: nextEcho(),
+	}
+	c.post(payload)
 }
 
 // SetGroupKick 群组踢人
@@ -256,9 +1177,9 @@ func (c *cqclient) SetGroupKick(groupID, userID int64, reject bool) {
 			UserID:           userID,
 			RejectAddRequest: reject,
 		},
-		Echo: time.Now().Unix(),
+		Echo: nextEcho(),
 	}
-	c.APISendJSON(payload)
+	c.post(payload)
 }
 
 // SetGroupBan 群组单人禁言
@@ -272,9 +1193,9 @@ func (c *cqclient) SetGroupBan(groupID, userID int64, duration int64) {
 			UserID:   userID,
 			Duration: duration,
 		},
-		Echo: time.Now().Unix(),
+		Echo: nextEcho(),
 	}
-	c.APISendJSON(payload)
+	c.post(payload)
 }
 
 // SetGroupWholeBan 群组全员禁言
@@ -287,9 +1208,215 @@ func (c *cqclient) SetGroupWholeBan(groupID int64, enable bool) {
 			GroupID: groupID,
 			Enable:  enable,
 		},
-		Echo: time.Now().Unix(),
+		Echo: nextEcho(),
+	}
+	c.post(payload)
+}
+
+// SetGroupCard 设置群名片
+// card 为空时取消群名片
+// websocket 接口
+func (c *cqclient) SetGroupCard(groupID, userID int64, card string) {
+	payload := &CQWSMessage{
+		Action: ActionSetGroupCard,
+		Params: CQTypeSetGroupCard{
+			GroupID: groupID,
+			UserID:  userID,
+			Card:    card,
+		},
+		Echo: nextEcho(),
+	}
+	c.post(payload)
+}
+
+// SetGroupName 设置群名
+// websocket 接口
+func (c *cqclient) SetGroupName(groupID int64, name string) {
+	payload := &CQWSMessage{
+		Action: ActionSetGroupName,
+		Params: CQTypeSetGroupName{
+			GroupID:   groupID,
+			GroupName: name,
+		},
+		Echo: nextEcho(),
+	}
+	c.post(payload)
+}
+
+// SetFriendAddRequest 处理加好友请求
+// flag 为请求事件中的 flag，remark 为通过后的好友备注
+// websocket 接口
+func (c *cqclient) SetFriendAddRequest(flag string, approve bool, remark string) {
+	payload := &CQWSMessage{
+		Action: ActionSetFriendAddRequest,
+		Params: CQTypeSetFriendAddRequest{
+			Flag:    flag,
+			Approve: approve,
+			Remark:  remark,
+		},
+		Echo: nextEcho(),
+	}
+	c.post(payload)
+}
+
+// SetGroupAddRequest 处理加群请求或者邀请
+// flag 和 subType 为请求事件中的 flag 和 sub_type("add" 或 "invite")
+// reason 为拒绝的理由，只在拒绝时有效
+// websocket 接口
+func (c *cqclient) SetGroupAddRequest(flag, subType string, approve bool, reason string) {
+	payload := &CQWSMessage{
+		Action: ActionSetGroupAddRequest,
+		Params: CQTypeSetGroupAddRequest{
+			Flag:    flag,
+			SubType: subType,
+			Approve: approve,
+			Reason:  reason,
+		},
+		Echo: nextEcho(),
+	}
+	c.post(payload)
+}
+
+// SetGroupLeave 退出群组
+// dismiss 为 true 并且机器人是群主时解散该群
+// websocket 接口
+func (c *cqclient) SetGroupLeave(groupID int64, dismiss bool) {
+	payload := &CQWSMessage{
+		Action: ActionSetGroupLeave,
+		Params: CQTypeSetGroupLeave{
+			GroupID:   groupID,
+			IsDismiss: dismiss,
+		},
+		Echo: nextEcho(),
+	}
+	c.post(payload)
+}
+
+// SendLike 给好友的名片点赞
+// times 为点赞次数，范围是 1-10，超出范围时取最接近的值
+// websocket 接口
+func (c *cqclient) SendLike(userID int64, times int) {
+	if times < 1 || times > 10 {
+		logger.Logger.Warnf("send_like times should be between 1 and 10, got %d\n", times)
+		if times < 1 {
+			times = 1
+		} else {
+			times = 10
+		}
+	}
+	payload := &CQWSMessage{
+		Action: ActionSendLike,
+		Params: CQTypeSendLike{
+			UserID: userID,
+			Times:  times,
+		},
+		Echo: nextEcho(),
+	}
+	c.post(payload)
+}
+
+// GetGroupMemberList 获取群成员列表
+// websocket 接口
+func (c *cqclient) GetGroupMemberList(groupID int64) ([]CQGroupMember, error) {
+	payload := &CQWSMessage{
+		Action: ActionGetGroupMemberList,
+		Params: CQTypeGetGroupMemberList{
+			GroupID: groupID,
+		},
+		Echo: nextEcho(),
+	}
+	res, err := c.apiCall(payload)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]CQGroupMember, 0)
+	if err := decodeData(res, &members); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// GetGroupMemberInfo 获取单个群成员的信息
+// noCache 为 true 时不使用缓存，速度较慢但是信息最新
+// websocket 接口
+func (c *cqclient) GetGroupMemberInfo(groupID, userID int64, noCache bool) (*CQGroupMember, error) {
+	payload := &CQWSMessage{
+		Action: ActionGetGroupMemberInfo,
+		Params: CQTypeGetGroupMemberInfo{
+			GroupID: groupID,
+			UserID:  userID,
+			NoCache: noCache,
+		},
+		Echo: nextEcho(),
+	}
+	res, err := c.apiCall(payload)
+	if err != nil {
+		return nil, err
+	}
+	member := new(CQGroupMember)
+	if err := decodeData(res, member); err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// GetGroupList 获取机器人加入的群列表
+// websocket 接口
+func (c *cqclient) GetGroupList() ([]CQGroup, error) {
+	payload := &CQWSMessage{
+		Action: ActionGetGroupList,
+		Params: struct{}{},
+		Echo:   nextEcho(),
+	}
+	res, err := c.apiCall(payload)
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]CQGroup, 0)
+	if err := decodeData(res, &groups); err != nil {
+		return nil, err
 	}
-	c.APISendJSON(payload)
+	return groups, nil
+}
+
+// GetLoginInfo 获取登录号信息
+// 返回机器人自己的QQ号和昵称，第一次成功获取后会被缓存
+// websocket 接口
+func (c *cqclient) GetLoginInfo() (int64, string, error) {
+	c.mu.Lock()
+	info := c.loginInfo
+	c.mu.Unlock()
+	if info != nil {
+		return info.UserID, info.Nickname, nil
+	}
+	payload := &CQWSMessage{
+		Action: ActionGetLoginInfo,
+		Params: struct{}{},
+		Echo:   nextEcho(),
+	}
+	res, err := c.apiCall(payload)
+	if err != nil {
+		return 0, "", err
+	}
+	info = new(CQTypeGetLoginInfo)
+	if err := decodeData(res, info); err != nil {
+		return 0, "", err
+	}
+	c.mu.Lock()
+	c.loginInfo = info
+	c.mu.Unlock()
+	return info.UserID, info.Nickname, nil
+}
+
+// SelfID 获取缓存的机器人QQ号
+// 还没有成功调用过 GetLoginInfo 时返回0
+func (c *cqclient) SelfID() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.loginInfo == nil {
+		return 0
+	}
+	return c.loginInfo.UserID
 }
 
 func warnHTTPApiURLNotSet() {
@@ -297,13 +1424,13 @@ func warnHTTPApiURLNotSet() {
 }
 
 func (c *cqclient) getAPIURL(api string) string {
-	return fmt.Sprintf("%s/%s", c.apiURL, api)
+	return fmt.Sprintf("%s/%s", c.httpAPIURL(), api)
 }
 
 // GetStatus 获取插件运行状态
 // http 接口
 func (c *cqclient) GetStatus() *CQTypeGetStatus {
-	if c.apiURL == "" {
+	if c.httpAPIURL() == "" {
 		warnHTTPApiURLNotSet()
 		return nil
 	}
@@ -334,8 +1461,20 @@ func (c *cqclient) GetStatus() *CQTypeGetStatus {
 }
 
 // Client 唯一的酷q机器人实体
-var Client = &cqclient{
-	apiConn:       new(clients.WSClient),
-	eventConn:     new(clients.WSClient),
-	pluginEntries: make(map[string]pluginEntry),
+var Client = newClient()
+
+func newClient() *cqclient {
+	return &cqclient{
+		apiConn:         new(clients.WSClient),
+		eventConn:       new(clients.WSClient),
+		pluginEntries:   make(map[string]pluginEntry),
+		echoqueue:       make(map[int64]echoWaiter),
+		echoTimeout:     defaultEchoTimeout,
+		cleanupInterval: defaultCleanupInterval,
+		failoverRetries: defaultFailoverRetries,
+		limiter:         newRateLimiter(0),
+		queue:           newSendQueue(),
+		dedup:           newDedupCache(),
+		outbox:          newOutbox(),
+	}
 }
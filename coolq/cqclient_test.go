@@ -0,0 +1,247 @@
+package coolq
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/haruno-bot/haruno/logger"
+)
+
+// TestMain 在临时目录中初始化日志服务，测试中的日志不会写到源码目录
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "haruno-coolq")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	logger.Service.SetLogsPath("logs")
+	logger.Service.Initialize()
+	code := m.Run()
+	logger.Service.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTestClient 创建一个已经初始化的客户端
+func newTestClient(t *testing.T, setup func(c *cqclient)) *cqclient {
+	t.Helper()
+	c := newClient()
+	if setup != nil {
+		setup(c)
+	}
+	c.Initialize("token")
+	return c
+}
+
+func TestReloadSettingsWhileSending(t *testing.T) {
+	c := newTestClient(t, func(c *cqclient) {
+		c.SetDryRun(true)
+	})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			c.SendGroupMsg(1, "hello world")
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			c.SetMaxMessageLength(i % 5)
+			c.SetSendRateLimit(0)
+			c.SetDedupSize(i)
+			c.SetOutboxSize(i % 10)
+			c.SetReceiveSelf(i%2 == 0)
+		}
+	}()
+	wg.Wait()
+}
+
+func TestRapidSendsUseUniqueEchoes(t *testing.T) {
+	echoes := make(chan int64, 1024)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, raw, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			msg := new(CQWSMessage)
+			if json.Unmarshal(raw, msg) == nil && r.URL.Path == "/api" {
+				echoes <- msg.Echo
+			}
+		}
+	}))
+	defer srv.Close()
+	c := newTestClient(t, nil)
+	defer c.Close()
+	c.Connect("ws"+strings.TrimPrefix(srv.URL, "http"), "")
+	const senders, perSender = 10, 20
+	var wg sync.WaitGroup
+	wg.Add(senders)
+	for i := 0; i < senders; i++ {
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < perSender; j++ {
+				if j%2 == 0 {
+					c.SendGroupMsg(int64(i), "hello")
+				} else {
+					// 没有设置echo的消息也会分配一个
+					c.APISendJSON(CQWSMessage{Action: ActionSendPrivateMsg, Params: CQTypeSendPrivateMsg{UserID: int64(i), Message: "hi"}})
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	seen := make(map[int64]bool)
+	timeout := time.After(5 * time.Second)
+	for len(seen) < senders*perSender {
+		select {
+		case echo := <-echoes:
+			if echo == 0 || seen[echo] {
+				t.Fatalf("echo %d is reused", echo)
+			}
+			seen[echo] = true
+		case <-timeout:
+			t.Fatalf("only %d of %d messages are received", len(seen), senders*perSender)
+		}
+	}
+}
+
+func TestShutdownStopsGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	srv, _ := newWSServer(t)
+	c := newTestClient(t, func(c *cqclient) {
+		c.SetWorkerPoolSize(4)
+		c.SetStatusPollInterval(10 * time.Millisecond)
+	})
+	r := new(recorder)
+	addTestPlugin(c, "plugin", r.handle)
+	c.Connect("ws"+strings.TrimPrefix(srv.URL, "http"), "")
+	c.eventConn.OnMessage(groupMessage(1))
+	// 和 haruno 关闭时的顺序相同
+	c.Drain(time.Second)
+	c.Close()
+	srv.Close()
+	if ids := r.list(); len(ids) != 1 {
+		t.Errorf("event is not handled before shutdown: %v", ids)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		buf := make([]byte, 1<<16)
+		t.Errorf("%d goroutines are still running after shutdown, want %d\n%s", n, before, buf[:runtime.Stack(buf, true)])
+	}
+}
+
+func TestConnectDedupesUpstreams(t *testing.T) {
+	srv, _ := newWSServer(t)
+	defer srv.Close()
+	primary := "ws" + strings.TrimPrefix(srv.URL, "http")
+	backup := "ws://127.0.0.1:1"
+	c := newTestClient(t, func(c *cqclient) {
+		c.SetFallbackURLs([]string{backup, primary, "", backup})
+	})
+	defer c.Close()
+	// 重新连接时不会重复添加主地址
+	c.Connect(primary, "")
+	c.Connect(primary, "")
+	c.mu.Lock()
+	upstreams := append([]string(nil), c.upstreams...)
+	c.mu.Unlock()
+	if want := []string{primary, backup}; !reflect.DeepEqual(upstreams, want) {
+		t.Errorf("upstreams = %v, want %v", upstreams, want)
+	}
+	if got := c.Upstream(); got != primary {
+		t.Errorf("active upstream = %s, want %s", got, primary)
+	}
+}
+
+func TestFailedResponseReturnsRetCode(t *testing.T) {
+	// api 对所有请求都返回失败
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, raw, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			msg := new(CQWSMessage)
+			if json.Unmarshal(raw, msg) != nil || r.URL.Path != "/api" {
+				continue
+			}
+			res := fmt.Sprintf(`{"status":"failed","retcode":102,"data":null,"echo":%d}`, msg.Echo)
+			if conn.WriteMessage(websocket.TextMessage, []byte(res)) != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+	c := newTestClient(t, nil)
+	defer c.Close()
+	c.Connect("ws"+strings.TrimPrefix(srv.URL, "http"), "")
+	deadline := time.Now().Add(2 * time.Second)
+	for !c.IsAPIOk() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !c.IsAPIOk() {
+		t.Fatal("api is not connected")
+	}
+	if _, err := c.GetGroupList(); err == nil || !strings.Contains(err.Error(), "retcode = 102") {
+		t.Errorf("GetGroupList should fail with the retcode, got %v", err)
+	}
+	if _, err := c.SendGroupMsgSync(1, "hello"); err == nil || !strings.Contains(err.Error(), "retcode = 102") {
+		t.Errorf("SendGroupMsgSync should fail with the retcode, got %v", err)
+	}
+}
+
+func TestReplyGroupAt(t *testing.T) {
+	c, closeFn := newDryRunClient(t)
+	defer closeFn()
+	// 不是群消息的时候不发送
+	c.ReplyGroupAt(decodeEvent(t, `{"post_type":"message","message_type":"private","user_id":2,"self_id":1,"message":"hi"}`), "pong")
+	c.ReplyGroupAt(decodeEvent(t, `{"post_type":"notice","notice_type":"group_increase","group_id":1,"user_id":2,"self_id":1}`), "welcome")
+	if got := c.Outbox(); len(got) != 0 {
+		t.Fatalf("nothing should be sent for non-group events, got %+v", got)
+	}
+	c.ReplyGroupAt(decodeEvent(t, string(groupMessage(1))), "see [CQ:face,id=1] & more")
+	got := c.Outbox()
+	if len(got) != 1 {
+		t.Fatalf("got %d messages, want 1", len(got))
+	}
+	want := OutboxEntry{
+		Action: ActionSendGroupMsg,
+		Target: 1,
+		Text:   "[CQ:at,qq=2] see &#91;CQ:face&#44;id=1&#93; &amp; more",
+	}
+	got[0].Time = 0
+	if got[0] != want {
+		t.Errorf("got %+v, want %+v", got[0], want)
+	}
+}
@@ -0,0 +1,105 @@
+package coolq
+
+import (
+	"strings"
+	"unicode"
+)
+
+// CommandOption 命令的选项
+type CommandOption func(*command)
+
+// RequireAtMe 命令必须@机器人才会触发
+// 私聊消息不受影响，机器人的QQ号优先使用 GetLoginInfo 缓存的结果
+func RequireAtMe() CommandOption {
+	return func(cmd *command) {
+		cmd.atMe = true
+	}
+}
+
+type command struct {
+	prefix string
+	atMe   bool
+}
+
+// Command 生成一对匹配命令前缀的 Filter 和 Handler
+// 消息去掉前缀后按空白分割成参数，支持用单引号或双引号包含空白的参数
+// 例如 `/say "hello world" 2` 的参数为 ["hello world", "2"]
+func Command(prefix string, handler func(event *CQEvent, args []string), opts ...CommandOption) (Filter, Handler) {
+	cmd := &command{prefix: prefix}
+	for _, opt := range opts {
+		opt(cmd)
+	}
+	filter := func(event *CQEvent) bool {
+		_, ok := cmd.match(event)
+		return ok
+	}
+	handle := func(event *CQEvent) {
+		if args, ok := cmd.match(event); ok {
+			handler(event, args)
+		}
+	}
+	return filter, handle
+}
+
+// match 检查消息是否是这个命令，是的话返回参数
+func (cmd *command) match(event *CQEvent) ([]string, bool) {
+	if event.PostType != PostTypeMessage {
+		return nil, false
+	}
+	text := event.Message
+	if cmd.atMe && event.MessageType == MessageTypeGroup {
+		selfID := Client.SelfID()
+		if selfID == 0 {
+			selfID = event.SelfID
+		}
+		at := CQAt(selfID)
+		if !strings.Contains(text, at) {
+			return nil, false
+		}
+		text = strings.Replace(text, at, "", -1)
+	}
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, cmd.prefix) {
+		return nil, false
+	}
+	rest := text[len(cmd.prefix):]
+	// 前缀后面必须是空白或者结束，避免 /help 匹配到 /helper
+	if rest != "" && !unicode.IsSpace([]rune(rest)[0]) {
+		return nil, false
+	}
+	return splitArgs(Unescape(rest)), true
+}
+
+// splitArgs 按空白分割参数，引号内的空白不分割
+func splitArgs(s string) []string {
+	args := make([]string, 0)
+	buff := new(strings.Builder)
+	inArg := false
+	var quote rune
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				buff.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, buff.String())
+				buff.Reset()
+				inArg = false
+			}
+		default:
+			buff.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, buff.String())
+	}
+	return args
+}
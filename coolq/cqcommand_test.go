@@ -0,0 +1,99 @@
+package coolq
+
+import (
+	"reflect"
+	"testing"
+)
+
+func commandEvent(messageType, message string) *CQEvent {
+	return &CQEvent{
+		PostType:    PostTypeMessage,
+		MessageType: messageType,
+		SelfID:      10000,
+		UserID:      1,
+		GroupID:     2,
+		Message:     message,
+	}
+}
+
+func TestCommand(t *testing.T) {
+	cases := []struct {
+		message string
+		match   bool
+		args    []string
+	}{
+		{"/say", true, []string{}},
+		{"  /say   ", true, []string{}},
+		{"/say hello world", true, []string{"hello", "world"}},
+		{"/say\thello\n world ", true, []string{"hello", "world"}},
+		{`/say "hello world" 2`, true, []string{"hello world", "2"}},
+		{`/say 'it"s' ""`, true, []string{`it"s`, ""}},
+		{`/say a"b c"d`, true, []string{"ab cd"}},
+		{`/say "unclosed quote`, true, []string{"unclosed quote"}},
+		{"/say a&#44;b &#91;c&#93;", true, []string{"a,b", "[c]"}},
+		{"/sayhello", false, nil},
+		{"/sa", false, nil},
+		{"hello /say", false, nil},
+		{"", false, nil},
+	}
+	for _, c := range cases {
+		var got []string
+		called := false
+		filter, handler := Command("/say", func(event *CQEvent, args []string) {
+			called = true
+			got = args
+		})
+		event := commandEvent(MessageTypeGroup, c.message)
+		if filter(event) != c.match {
+			t.Errorf("filter(%q) = %v, want %v", c.message, !c.match, c.match)
+			continue
+		}
+		handler(event)
+		if called != c.match {
+			t.Errorf("handler called = %v for %q", called, c.message)
+			continue
+		}
+		if c.match && !reflect.DeepEqual(got, c.args) {
+			t.Errorf("args of %q = %q, want %q", c.message, got, c.args)
+		}
+	}
+}
+
+func TestCommandIgnoresOtherEvents(t *testing.T) {
+	filter, _ := Command("/say", func(*CQEvent, []string) {})
+	event := commandEvent(MessageTypeGroup, "/say hi")
+	event.PostType = PostTypeNotice
+	if filter(event) {
+		t.Error("command should only match messages")
+	}
+}
+
+func TestCommandRequireAtMe(t *testing.T) {
+	var got []string
+	filter, handler := Command("/say", func(event *CQEvent, args []string) {
+		got = args
+	}, RequireAtMe())
+	cases := []struct {
+		messageType string
+		message     string
+		match       bool
+	}{
+		{MessageTypeGroup, "[CQ:at,qq=10000] /say hi", true},
+		{MessageTypeGroup, "/say hi [CQ:at,qq=10000]", true},
+		{MessageTypeGroup, "/say hi", false},
+		{MessageTypeGroup, "[CQ:at,qq=10001] /say hi", false},
+		{MessageTypePrivate, "/say hi", true},
+	}
+	for _, c := range cases {
+		got = nil
+		event := commandEvent(c.messageType, c.message)
+		if filter(event) != c.match {
+			t.Errorf("filter(%s %q) = %v, want %v", c.messageType, c.message, !c.match, c.match)
+			continue
+		}
+		handler(event)
+		if c.match && !reflect.DeepEqual(got, []string{"hi"}) {
+			t.Errorf("args of %q = %q, want [hi]", c.message, got)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package coolq
+
+import (
+	"sync"
+	"time"
+)
+
+// Cooldown 按key(用户、群等)限制触发频率
+// 同一个key在上一次允许之后的 d 时间内不会再被允许
+// 可以在多个协程中同时使用
+type Cooldown struct {
+	mu        sync.Mutex
+	d         time.Duration
+	last      map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewCooldown 创建一个冷却时间为 d 的 Cooldown
+func NewCooldown(d time.Duration) *Cooldown {
+	return &Cooldown{
+		d:         d,
+		last:      make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow 检查key是否已经冷却，允许时重新开始计时
+// 一般使用 strconv.FormatInt(event.UserID, 10) 或者群号作为key
+func (cd *Cooldown) Allow(key string) bool {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+	now := time.Now()
+	cd.sweep(now)
+	if last, ok := cd.last[key]; ok && now.Sub(last) < cd.d {
+		return false
+	}
+	cd.last[key] = now
+	return true
+}
+
+// Reset 清除key的冷却时间
+func (cd *Cooldown) Reset(key string) {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+	delete(cd.last, key)
+}
+
+// sweep 每隔一个冷却时间清理一次已经冷却的key，调用时需要持有锁
+func (cd *Cooldown) sweep(now time.Time) {
+	if now.Sub(cd.lastSweep) < cd.d {
+		return
+	}
+	cd.lastSweep = now
+	for key, last := range cd.last {
+		if now.Sub(last) >= cd.d {
+			delete(cd.last, key)
+		}
+	}
+}
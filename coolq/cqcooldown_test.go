@@ -0,0 +1,74 @@
+package coolq
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCooldownConcurrentAllow(t *testing.T) {
+	cd := NewCooldown(time.Hour)
+	const keys, workers = 8, 16
+	allowed := make([]int32, keys)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := j % keys
+				if cd.Allow(fmt.Sprintf("k%d", key)) {
+					atomic.AddInt32(&allowed[key], 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	// 冷却时间内每个key只允许一次
+	for key, n := range allowed {
+		if n != 1 {
+			t.Errorf("key k%d is allowed %d times, want 1", key, n)
+		}
+	}
+}
+
+func TestCooldownExpiry(t *testing.T) {
+	cd := NewCooldown(50 * time.Millisecond)
+	if !cd.Allow("a") || !cd.Allow("b") {
+		t.Fatal("first call should be allowed")
+	}
+	if cd.Allow("a") {
+		t.Fatal("key in cooldown should not be allowed")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if !cd.Allow("a") {
+		t.Fatal("key should be allowed after the cooldown")
+	}
+	if cd.Allow("a") {
+		t.Fatal("cooldown should restart after being allowed")
+	}
+	cd.Reset("a")
+	if !cd.Allow("a") {
+		t.Fatal("key should be allowed after reset")
+	}
+}
+
+func TestCooldownSweep(t *testing.T) {
+	cd := NewCooldown(20 * time.Millisecond)
+	for i := 0; i < 100; i++ {
+		cd.Allow(fmt.Sprintf("k%d", i))
+	}
+	time.Sleep(30 * time.Millisecond)
+	// 下一次调用时清理已经冷却的key
+	cd.Allow("new")
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+	if len(cd.last) != 1 {
+		t.Errorf("%d keys are kept after sweeping, want 1", len(cd.last))
+	}
+	if _, ok := cd.last["new"]; !ok {
+		t.Error("key in cooldown is swept")
+	}
+}
@@ -0,0 +1,73 @@
+package coolq
+
+import (
+	"container/list"
+	"sync"
+)
+
+// defaultDedupSize 默认记住的最近消息数
+const defaultDedupSize = 256
+
+// dedupKey 区分一条消息上报
+// 重连后重新上报的事件 message_id 和 time 都相同
+type dedupKey struct {
+	messageID int64
+	time      int64
+}
+
+// dedupCache 记住最近处理过的消息，用来跳过重复的上报
+// 超过容量时最久没有出现的消息先被淘汰
+type dedupCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	seen  map[dedupKey]*list.Element
+}
+
+func newDedupCache() *dedupCache {
+	return &dedupCache{
+		size:  defaultDedupSize,
+		order: list.New(),
+		seen:  make(map[dedupKey]*list.Element),
+	}
+}
+
+// setSize 设置记住的消息数，size < 0 时不去重，size == 0 时使用默认值
+func (d *dedupCache) setSize(size int) {
+	if size == 0 {
+		size = defaultDedupSize
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.size = size
+	d.evict()
+}
+
+// seenBefore 记录一条消息，返回它是否已经出现过
+func (d *dedupCache) seenBefore(key dedupKey) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.size < 0 {
+		return false
+	}
+	if elem, ok := d.seen[key]; ok {
+		d.order.MoveToFront(elem)
+		return true
+	}
+	d.seen[key] = d.order.PushFront(key)
+	d.evict()
+	return false
+}
+
+// evict 淘汰超过容量的消息，调用时需要持有 mu
+func (d *dedupCache) evict() {
+	limit := d.size
+	if limit < 0 {
+		limit = 0
+	}
+	for d.order.Len() > limit {
+		elem := d.order.Back()
+		d.order.Remove(elem)
+		delete(d.seen, elem.Value.(dedupKey))
+	}
+}
@@ -0,0 +1,22 @@
+package coolq
+
+import "testing"
+
+func TestDedupCacheEvictsOldest(t *testing.T) {
+	d := newDedupCache()
+	d.setSize(2)
+	if d.seenBefore(dedupKey{1, 1}) || d.seenBefore(dedupKey{2, 1}) {
+		t.Fatal("new messages should not be seen before")
+	}
+	// 同一个 message_id 时间不同时是不同的消息
+	if d.seenBefore(dedupKey{1, 2}) {
+		t.Fatal("message with another time should not be seen before")
+	}
+	// {1, 1} 已经被淘汰
+	if d.seenBefore(dedupKey{1, 1}) {
+		t.Error("the oldest message should be evicted")
+	}
+	if !d.seenBefore(dedupKey{1, 1}) {
+		t.Error("duplicated message is not detected")
+	}
+}
@@ -0,0 +1,263 @@
+package coolq
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/haruno-bot/haruno/logger"
+)
+
+// addTestPlugin 直接注册一个只有无filter handler的插件
+func addTestPlugin(c *cqclient, name string, handler Handler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pluginEntries[name] = pluginEntry{
+		keys:     make([]string, 0),
+		fitlers:  make(map[string]Filter),
+		handlers: map[string]Handler{noFilterKey: handler},
+		meta:     PluginMeta{Name: name},
+		enabled:  true,
+	}
+	c.pluginOrder = append(c.pluginOrder, name)
+}
+
+// groupMessage 生成一条群消息上报
+func groupMessage(id int) []byte {
+	return []byte(fmt.Sprintf(`{"post_type":"message","message_type":"group","message_id":%d,"time":1,"group_id":1,"user_id":2,"self_id":1,"message":"hello"}`, id))
+}
+
+// recorder 记录插件收到的消息id
+type recorder struct {
+	mu  sync.Mutex
+	ids []int64
+}
+
+func (r *recorder) handle(event *CQEvent) {
+	r.mu.Lock()
+	r.ids = append(r.ids, event.MessageID)
+	r.mu.Unlock()
+}
+
+func (r *recorder) list() []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int64(nil), r.ids...)
+}
+
+func TestEventOrderPerPlugin(t *testing.T) {
+	const total = 300
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for i := 1; i <= total; i++ {
+			conn.WriteMessage(websocket.TextMessage, groupMessage(i))
+		}
+		// 保持连接直到客户端关闭
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+	c := newTestClient(t, func(c *cqclient) {
+		c.SetDryRun(true)
+		c.SetWorkerPoolSize(4)
+	})
+	defer c.Close()
+	recorders := []*recorder{new(recorder), new(recorder), new(recorder)}
+	for i, r := range recorders {
+		addTestPlugin(c, fmt.Sprintf("plugin%d", i), r.handle)
+	}
+	c.Connect("ws"+strings.TrimPrefix(srv.URL, "http"), "")
+	deadline := time.Now().Add(5 * time.Second)
+	for i, r := range recorders {
+		for len(r.list()) < total && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		ids := r.list()
+		if len(ids) != total {
+			t.Fatalf("plugin%d received %d events, want %d", i, len(ids), total)
+		}
+		for j, id := range ids {
+			if id != int64(j+1) {
+				t.Fatalf("plugin%d received event %d at position %d", i, id, j)
+			}
+		}
+	}
+}
+
+// waitEvents 等待插件收到 n 个事件，再稍等一会确认没有多余的事件
+func waitEvents(t *testing.T, r *recorder, n int) []int64 {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(r.list()) < n && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	return r.list()
+}
+
+func TestDuplicateEventDispatchedOnce(t *testing.T) {
+	c := newTestClient(t, nil)
+	defer c.Close()
+	r := new(recorder)
+	addTestPlugin(c, "plugin", r.handle)
+	c.eventConn.OnMessage(groupMessage(1))
+	c.eventConn.OnMessage(groupMessage(1))
+	c.eventConn.OnMessage(groupMessage(2))
+	if ids := waitEvents(t, r, 2); len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Fatalf("plugin received %v, want [1 2]", ids)
+	}
+}
+
+func TestDuplicateEventWithDedupDisabled(t *testing.T) {
+	c := newTestClient(t, func(c *cqclient) {
+		c.SetDedupSize(-1)
+	})
+	defer c.Close()
+	r := new(recorder)
+	addTestPlugin(c, "plugin", r.handle)
+	c.eventConn.OnMessage(groupMessage(1))
+	c.eventConn.OnMessage(groupMessage(1))
+	if ids := waitEvents(t, r, 2); len(ids) != 2 {
+		t.Fatalf("plugin received %v, want the event twice", ids)
+	}
+}
+
+// selfMessage 生成一条机器人自己发送的群消息上报
+func selfMessage(id int) []byte {
+	return []byte(fmt.Sprintf(`{"post_type":"message","message_type":"group","message_id":%d,"time":1,"group_id":1,"user_id":1,"self_id":1,"message":"reply"}`, id))
+}
+
+// echoPlugin 模拟一个回复所有消息的插件，回复的消息会像 go-cqhttp 一样再次上报
+// 最多回复 limit 次，避免测试失败时无限循环
+func echoPlugin(c *cqclient, r *recorder, limit int) Handler {
+	var mu sync.Mutex
+	replies := 0
+	return func(event *CQEvent) {
+		r.handle(event)
+		mu.Lock()
+		defer mu.Unlock()
+		if replies < limit {
+			replies++
+			c.eventConn.OnMessage(selfMessage(1000 + replies))
+		}
+	}
+}
+
+func TestSelfMessageLoopPrevented(t *testing.T) {
+	c := newTestClient(t, nil)
+	defer c.Close()
+	r := new(recorder)
+	addTestPlugin(c, "echo", echoPlugin(c, r, 10))
+	c.eventConn.OnMessage(groupMessage(1))
+	if ids := waitEvents(t, r, 1); len(ids) != 1 || ids[0] != 1 {
+		t.Fatalf("plugin received %v, want only [1]", ids)
+	}
+}
+
+func TestReceiveSelfMessages(t *testing.T) {
+	c := newTestClient(t, func(c *cqclient) {
+		c.SetReceiveSelf(true)
+	})
+	defer c.Close()
+	r := new(recorder)
+	addTestPlugin(c, "echo", echoPlugin(c, r, 3))
+	c.eventConn.OnMessage(groupMessage(1))
+	if ids := waitEvents(t, r, 4); len(ids) != 4 {
+		t.Fatalf("plugin received %v, want the message and 3 self messages", ids)
+	}
+}
+
+func TestSlowPluginDoesNotBlockReading(t *testing.T) {
+	c := newTestClient(t, nil)
+	defer c.Close()
+	block := make(chan struct{})
+	addTestPlugin(c, "slow", func(*CQEvent) {
+		<-block
+	})
+	defer close(block)
+	// 插件卡住之后队列很快会满，之后的事件被丢弃而不是让读取协程等待
+	start := time.Now()
+	for i := 1; i <= jobQueueSize*2; i++ {
+		c.eventConn.OnMessage(groupMessage(i))
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("handling events blocks for %v while a plugin is stuck", elapsed)
+	}
+	if c.pool.droppedCount() == 0 {
+		t.Error("events should be dropped when the worker queue is full")
+	}
+}
+
+func TestChainFailureIsReported(t *testing.T) {
+	timeout := jobQueueTimeout
+	jobQueueTimeout = 20 * time.Millisecond
+	defer func() {
+		jobQueueTimeout = timeout
+	}()
+	c := newTestClient(t, func(c *cqclient) {
+		c.SetWorkerPoolSize(1)
+	})
+	defer c.Close()
+	// 第一个插件处理时占满 worker 的 chained 队列，事件交不到第二个插件
+	addTestPlugin(c, "a-first", func(*CQEvent) {
+		for i := 0; i < jobQueueSize; i++ {
+			c.pool.chain("filler", func() {})
+		}
+	})
+	second := new(recorder)
+	addTestPlugin(c, "b-second", second.handle)
+	c.eventConn.OnMessage(groupMessage(1))
+	c.Drain(time.Second)
+	if ids := second.list(); len(ids) != 0 {
+		t.Fatalf("second plugin should miss the event, got %v", ids)
+	}
+	for _, lg := range logger.Service.Recent() {
+		if lg.Type == logger.LogTypeError && strings.Contains(lg.Text, "event message can't be handed over to plugin b-second") {
+			return
+		}
+	}
+	t.Error("failure to hand over the event is not reported")
+}
+
+// benchmarkSlowHandlers 分发事件给几个处理很慢的插件
+func benchmarkSlowHandlers(b *testing.B, workers int) {
+	c := newClient()
+	c.SetWorkerPoolSize(workers)
+	c.SetDedupSize(-1)
+	c.Initialize("token")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		addTestPlugin(c, fmt.Sprintf("slow%d", i), func(event *CQEvent) {
+			time.Sleep(100 * time.Microsecond)
+			wg.Done()
+		})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		wg.Add(8)
+		event := &CQEvent{PostType: PostTypeMessage, MessageID: int64(i + 1), UserID: 2, SelfID: 1}
+		c.dispatch(event)
+	}
+	wg.Wait()
+}
+
+func BenchmarkDispatchSlowHandlersOneWorker(b *testing.B) {
+	benchmarkSlowHandlers(b, 1)
+}
+
+func BenchmarkDispatchSlowHandlersPool(b *testing.B) {
+	benchmarkSlowHandlers(b, 8)
+}
@@ -0,0 +1,80 @@
+package coolq
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter 令牌桶限流器
+// rate 为每秒产生的令牌数，桶的容量为 max(1, rate)
+// rate <= 0 时不限流
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	limiter := new(rateLimiter)
+	limiter.SetRate(rate)
+	return limiter
+}
+
+// SetRate 修改速率，可以在运行时调用
+func (limiter *rateLimiter) SetRate(rate float64) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	limiter.rate = rate
+	limiter.tokens = limiter.burst()
+	limiter.last = time.Now()
+}
+
+func (limiter *rateLimiter) burst() float64 {
+	if limiter.rate < 1 {
+		return 1
+	}
+	return limiter.rate
+}
+
+// refill 按经过的时间补充令牌，调用时需要持有锁
+func (limiter *rateLimiter) refill() {
+	now := time.Now()
+	limiter.tokens += now.Sub(limiter.last).Seconds() * limiter.rate
+	if burst := limiter.burst(); limiter.tokens > burst {
+		limiter.tokens = burst
+	}
+	limiter.last = now
+}
+
+// Allow 尝试取一个令牌，没有令牌时立即返回 false
+func (limiter *rateLimiter) Allow() bool {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	if limiter.rate <= 0 {
+		return true
+	}
+	limiter.refill()
+	if limiter.tokens < 1 {
+		return false
+	}
+	limiter.tokens--
+	return true
+}
+
+// Wait 取一个令牌，没有令牌时阻塞到有令牌为止
+func (limiter *rateLimiter) Wait() {
+	limiter.mu.Lock()
+	if limiter.rate <= 0 {
+		limiter.mu.Unlock()
+		return
+	}
+	limiter.refill()
+	// 先预定令牌，令牌数可以为负，表示需要等待的时间
+	limiter.tokens--
+	wait := time.Duration(-limiter.tokens / limiter.rate * float64(time.Second))
+	limiter.mu.Unlock()
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
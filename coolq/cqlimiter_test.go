@@ -0,0 +1,74 @@
+package coolq
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// newDryRunClient 创建一个连接到模拟服务的 dry run 客户端，发送的消息不会真正写出
+// 用完之后调用返回的 closeFn 关闭客户端和服务
+func newDryRunClient(t *testing.T) (c *cqclient, closeFn func()) {
+	t.Helper()
+	srv, _ := newWSServer(t)
+	c = newTestClient(t, func(c *cqclient) {
+		c.SetDryRun(true)
+	})
+	c.Connect("ws"+strings.TrimPrefix(srv.URL, "http"), "")
+	return c, func() {
+		c.Close()
+		srv.Close()
+	}
+}
+
+func TestSendRateLimitPacing(t *testing.T) {
+	c, closeFn := newDryRunClient(t)
+	defer closeFn()
+	c.SetSendRateLimit(50)
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		if err := c.SendGroupMsgErr(1, "hello"); err != nil {
+			t.Fatalf("send %d failed: %v", i, err)
+		}
+	}
+	// 前50条用掉桶里的令牌，后50条按每秒50条发送
+	elapsed := time.Since(start)
+	if elapsed < 900*time.Millisecond || elapsed > 3*time.Second {
+		t.Errorf("100 sends at 50/s took %v, want about 1s", elapsed)
+	}
+}
+
+func TestSendRateLimitDrops(t *testing.T) {
+	c, closeFn := newDryRunClient(t)
+	defer closeFn()
+	c.SetSendRateLimit(10)
+	sent := 0
+	for i := 0; i < 100; i++ {
+		err := c.sendLimited(newSendGroupMsg(1, "hello"), false)
+		switch err {
+		case nil:
+			sent++
+		case ErrRateLimited:
+		default:
+			t.Fatalf("send %d failed: %v", i, err)
+		}
+	}
+	if sent < 10 || sent > 12 {
+		t.Errorf("%d of 100 messages are sent at 10/s, want about 10", sent)
+	}
+}
+
+func TestSendRateLimitDisabled(t *testing.T) {
+	c, closeFn := newDryRunClient(t)
+	defer closeFn()
+	c.SetSendRateLimit(0)
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		if err := c.sendLimited(newSendGroupMsg(1, "hello"), false); err != nil {
+			t.Fatalf("send %d failed without rate limit: %v", i, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("100 sends without rate limit took %v", elapsed)
+	}
+}
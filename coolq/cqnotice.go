@@ -0,0 +1,187 @@
+package coolq
+
+// 通知类型
+const (
+	// NoticeTypeGroupIncrease 群成员增加
+	NoticeTypeGroupIncrease = "group_increase"
+	// NoticeTypeGroupDecrease 群成员减少
+	NoticeTypeGroupDecrease = "group_decrease"
+	// NoticeTypeGroupAdmin 群管理员变动
+	NoticeTypeGroupAdmin = "group_admin"
+	// NoticeTypeGroupBan 群禁言
+	NoticeTypeGroupBan = "group_ban"
+	// NoticeTypeGroupRecall 群消息撤回
+	NoticeTypeGroupRecall = "group_recall"
+	// NoticeTypeFriendAdd 好友添加
+	NoticeTypeFriendAdd = "friend_add"
+	// NoticeTypeFriendRecall 好友消息撤回
+	NoticeTypeFriendRecall = "friend_recall"
+	// NoticeTypeNotify 群内提示(戳一戳等)
+	NoticeTypeNotify = "notify"
+)
+
+// 通知的子类型
+const (
+	// SubTypeApprove 管理员同意入群
+	SubTypeApprove = "approve"
+	// SubTypeInvite 管理员邀请入群
+	SubTypeInvite = "invite"
+	// SubTypeLeave 主动退群
+	SubTypeLeave = "leave"
+	// SubTypeKick 成员被踢
+	SubTypeKick = "kick"
+	// SubTypeKickMe 机器人被踢
+	SubTypeKickMe = "kick_me"
+	// SubTypeSet 设置管理员
+	SubTypeSet = "set"
+	// SubTypeUnset 取消管理员
+	SubTypeUnset = "unset"
+	// SubTypeBan 禁言
+	SubTypeBan = "ban"
+	// SubTypeLiftBan 解除禁言
+	SubTypeLiftBan = "lift_ban"
+	// SubTypePoke 戳一戳
+	SubTypePoke = "poke"
+)
+
+// GroupIncreaseNotice 群成员增加
+// SubType 为 approve 或 invite
+type GroupIncreaseNotice struct {
+	GroupID    int64
+	UserID     int64
+	OperatorID int64
+}
+
+// GroupDecreaseNotice 群成员减少
+// SubType 为 leave、kick 或 kick_me，主动退群时 OperatorID 和 UserID 相同
+type GroupDecreaseNotice struct {
+	GroupID    int64
+	UserID     int64
+	OperatorID int64
+}
+
+// GroupAdminNotice 群管理员变动
+// SubType 为 set 或 unset
+type GroupAdminNotice struct {
+	GroupID int64
+	UserID  int64
+}
+
+// GroupBanNotice 群禁言
+// SubType 为 ban 或 lift_ban，UserID 为0时表示全员禁言，Duration 单位为秒
+type GroupBanNotice struct {
+	GroupID    int64
+	UserID     int64
+	OperatorID int64
+	Duration   int64
+}
+
+// GroupRecallNotice 群消息撤回
+// 自己撤回时 OperatorID 和 UserID 相同
+type GroupRecallNotice struct {
+	GroupID    int64
+	UserID     int64
+	OperatorID int64
+	MessageID  int64
+}
+
+// FriendAddNotice 好友添加
+type FriendAddNotice struct {
+	UserID int64
+}
+
+// FriendRecallNotice 好友消息撤回
+type FriendRecallNotice struct {
+	UserID    int64
+	MessageID int64
+}
+
+// PokeNotice 群内戳一戳
+// UserID 戳了 TargetID
+type PokeNotice struct {
+	GroupID  int64
+	UserID   int64
+	TargetID int64
+}
+
+// CQNotice 通知上报
+// 根据 NoticeType 和 SubType 设置下面的其中一个字段，不认识的通知类型全部为 nil
+type CQNotice struct {
+	NoticeType    string
+	SubType       string
+	Time          int64
+	SelfID        int64
+	GroupIncrease *GroupIncreaseNotice
+	GroupDecrease *GroupDecreaseNotice
+	GroupAdmin    *GroupAdminNotice
+	GroupBan      *GroupBanNotice
+	GroupRecall   *GroupRecallNotice
+	FriendAdd     *FriendAddNotice
+	FriendRecall  *FriendRecallNotice
+	Poke          *PokeNotice
+}
+
+// AsNotice 把通知上报转换成 CQNotice
+// 不是通知上报时返回 false
+func (event *CQEvent) AsNotice() (*CQNotice, bool) {
+	if event.PostType != PostTypeNotice {
+		return nil, false
+	}
+	notice := &CQNotice{
+		NoticeType: event.NoticeType,
+		SubType:    event.SubType,
+		Time:       event.Time,
+		SelfID:     event.SelfID,
+	}
+	switch event.NoticeType {
+	case NoticeTypeGroupIncrease:
+		notice.GroupIncrease = &GroupIncreaseNotice{
+			GroupID:    event.GroupID,
+			UserID:     event.UserID,
+			OperatorID: event.OperatorID,
+		}
+	case NoticeTypeGroupDecrease:
+		notice.GroupDecrease = &GroupDecreaseNotice{
+			GroupID:    event.GroupID,
+			UserID:     event.UserID,
+			OperatorID: event.OperatorID,
+		}
+	case NoticeTypeGroupAdmin:
+		notice.GroupAdmin = &GroupAdminNotice{
+			GroupID: event.GroupID,
+			UserID:  event.UserID,
+		}
+	case NoticeTypeGroupBan:
+		notice.GroupBan = &GroupBanNotice{
+			GroupID:    event.GroupID,
+			UserID:     event.UserID,
+			OperatorID: event.OperatorID,
+			Duration:   event.Duration,
+		}
+	case NoticeTypeGroupRecall:
+		notice.GroupRecall = &GroupRecallNotice{
+			GroupID:    event.GroupID,
+			UserID:     event.UserID,
+			OperatorID: event.OperatorID,
+			MessageID:  event.MessageID,
+		}
+	case NoticeTypeFriendAdd:
+		notice.FriendAdd = &FriendAddNotice{
+			UserID: event.UserID,
+		}
+	case NoticeTypeFriendRecall:
+		notice.FriendRecall = &FriendRecallNotice{
+			UserID:    event.UserID,
+			MessageID: event.MessageID,
+		}
+	case NoticeTypeNotify:
+		if event.SubType == SubTypePoke {
+			notice.Poke = &PokeNotice{
+				GroupID:  event.GroupID,
+				UserID:   event.UserID,
+				TargetID: event.TargetID,
+			}
+		}
+	}
+	return notice, true
+}
@@ -0,0 +1,90 @@
+package coolq
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func decodeEvent(t *testing.T, raw string) *CQEvent {
+	t.Helper()
+	event := new(CQEvent)
+	if err := json.Unmarshal([]byte(raw), event); err != nil {
+		t.Fatalf("%s: %v", raw, err)
+	}
+	return event
+}
+
+func TestAsNotice(t *testing.T) {
+	cases := []struct {
+		raw  string
+		want CQNotice
+	}{
+		{
+			`{"post_type":"notice","notice_type":"group_increase","sub_type":"approve","time":1,"self_id":10,"group_id":100,"user_id":2,"operator_id":3}`,
+			CQNotice{NoticeType: NoticeTypeGroupIncrease, SubType: SubTypeApprove, Time: 1, SelfID: 10,
+				GroupIncrease: &GroupIncreaseNotice{GroupID: 100, UserID: 2, OperatorID: 3}},
+		},
+		{
+			`{"post_type":"notice","notice_type":"group_decrease","sub_type":"kick_me","time":1,"self_id":10,"group_id":100,"user_id":10,"operator_id":3}`,
+			CQNotice{NoticeType: NoticeTypeGroupDecrease, SubType: SubTypeKickMe, Time: 1, SelfID: 10,
+				GroupDecrease: &GroupDecreaseNotice{GroupID: 100, UserID: 10, OperatorID: 3}},
+		},
+		{
+			`{"post_type":"notice","notice_type":"group_admin","sub_type":"set","time":1,"self_id":10,"group_id":100,"user_id":2}`,
+			CQNotice{NoticeType: NoticeTypeGroupAdmin, SubType: SubTypeSet, Time: 1, SelfID: 10,
+				GroupAdmin: &GroupAdminNotice{GroupID: 100, UserID: 2}},
+		},
+		{
+			`{"post_type":"notice","notice_type":"group_ban","sub_type":"ban","time":1,"self_id":10,"group_id":100,"user_id":0,"operator_id":3,"duration":600}`,
+			CQNotice{NoticeType: NoticeTypeGroupBan, SubType: SubTypeBan, Time: 1, SelfID: 10,
+				GroupBan: &GroupBanNotice{GroupID: 100, UserID: 0, OperatorID: 3, Duration: 600}},
+		},
+		{
+			`{"post_type":"notice","notice_type":"group_recall","time":1,"self_id":10,"group_id":100,"user_id":2,"operator_id":2,"message_id":-123}`,
+			CQNotice{NoticeType: NoticeTypeGroupRecall, Time: 1, SelfID: 10,
+				GroupRecall: &GroupRecallNotice{GroupID: 100, UserID: 2, OperatorID: 2, MessageID: -123}},
+		},
+		{
+			`{"post_type":"notice","notice_type":"friend_add","time":1,"self_id":10,"user_id":2}`,
+			CQNotice{NoticeType: NoticeTypeFriendAdd, Time: 1, SelfID: 10,
+				FriendAdd: &FriendAddNotice{UserID: 2}},
+		},
+		{
+			`{"post_type":"notice","notice_type":"friend_recall","time":1,"self_id":10,"user_id":2,"message_id":456}`,
+			CQNotice{NoticeType: NoticeTypeFriendRecall, Time: 1, SelfID: 10,
+				FriendRecall: &FriendRecallNotice{UserID: 2, MessageID: 456}},
+		},
+		{
+			`{"post_type":"notice","notice_type":"notify","sub_type":"poke","time":1,"self_id":10,"group_id":100,"user_id":2,"target_id":10}`,
+			CQNotice{NoticeType: NoticeTypeNotify, SubType: SubTypePoke, Time: 1, SelfID: 10,
+				Poke: &PokeNotice{GroupID: 100, UserID: 2, TargetID: 10}},
+		},
+		// 不认识的通知类型只有基本信息
+		{
+			`{"post_type":"notice","notice_type":"notify","sub_type":"lucky_king","time":1,"self_id":10,"group_id":100,"user_id":2,"target_id":3}`,
+			CQNotice{NoticeType: NoticeTypeNotify, SubType: "lucky_king", Time: 1, SelfID: 10},
+		},
+		{
+			`{"post_type":"notice","notice_type":"group_upload","time":1,"self_id":10,"group_id":100,"user_id":2}`,
+			CQNotice{NoticeType: "group_upload", Time: 1, SelfID: 10},
+		},
+	}
+	for _, c := range cases {
+		notice, ok := decodeEvent(t, c.raw).AsNotice()
+		if !ok {
+			t.Errorf("%s is not a notice", c.raw)
+			continue
+		}
+		if !reflect.DeepEqual(*notice, c.want) {
+			t.Errorf("AsNotice(%s) = %+v, want %+v", c.raw, *notice, c.want)
+		}
+	}
+}
+
+func TestAsNoticeOtherPostType(t *testing.T) {
+	event := decodeEvent(t, `{"post_type":"message","message_type":"group","notice_type":"group_recall","message":"hi"}`)
+	if notice, ok := event.AsNotice(); ok || notice != nil {
+		t.Errorf("message event should not be a notice, got %+v", notice)
+	}
+}
@@ -0,0 +1,89 @@
+package coolq
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/haruno-bot/haruno/logger"
+)
+
+// defaultOutboxSize 默认保存的最近发送的消息数
+const defaultOutboxSize = 50
+
+// OutboxEntry 一条发送出去的消息
+// Target 为群号或者QQ号，由 Action 决定
+type OutboxEntry struct {
+	Time   int64  `json:"time"`
+	Action string `json:"action"`
+	Target int64  `json:"target"`
+	Text   string `json:"text"`
+}
+
+// outbox 保存最近发送的消息
+type outbox struct {
+	ring *logger.Ring
+}
+
+func newOutbox() *outbox {
+	return &outbox{ring: logger.NewRing(defaultOutboxSize)}
+}
+
+// setSize 设置保存的消息数，size < 0 时不保存，size == 0 时使用默认值
+// 缩小时只保留最新的消息
+func (box *outbox) setSize(size int) {
+	if size == 0 {
+		size = defaultOutboxSize
+	}
+	box.ring.Resize(size)
+}
+
+// list 按从旧到新的顺序返回保存的消息
+func (box *outbox) list() []OutboxEntry {
+	items := box.ring.List()
+	entries := make([]OutboxEntry, len(items))
+	for i, item := range items {
+		entries[i] = item.(OutboxEntry)
+	}
+	return entries
+}
+
+// record 记录一条发送消息的动作，其他动作会被忽略
+// 和日志一样会屏蔽文本中的ip地址
+func (box *outbox) record(payload *CQWSMessage) {
+	var target int64
+	var text string
+	switch params := payload.Params.(type) {
+	case CQTypeSendGroupMsg:
+		target, text = params.GroupID, params.Message
+	case CQTypeSendGroupMsgSegments:
+		target, text = params.GroupID, SegmentsString(params.Message)
+	case CQTypeSendPrivateMsg:
+		target, text = params.UserID, params.Message
+	case CQTypeSendMsg:
+		target, text = params.UserID, params.Message
+		if params.MessageType == MessageTypeGroup {
+			target = params.GroupID
+		}
+	case CQTypeSendGroupForwardMsg:
+		target, text = params.GroupID, fmt.Sprintf("(forward message of %d nodes)", len(params.Messages))
+	default:
+		return
+	}
+	box.ring.Push(OutboxEntry{
+		Time:   time.Now().Unix(),
+		Action: payload.Action,
+		Target: target,
+		Text:   logger.Service.MaskIPs(text),
+	})
+}
+
+// SetOutboxSize 设置保存的最近发送的消息数
+// size == 0 时使用默认值 50，size < 0 时不保存
+func (c *cqclient) SetOutboxSize(size int) {
+	c.outbox.setSize(size)
+}
+
+// Outbox 获取最近发送的消息，从旧到新排列
+func (c *cqclient) Outbox() []OutboxEntry {
+	return c.outbox.list()
+}
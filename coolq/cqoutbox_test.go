@@ -0,0 +1,65 @@
+package coolq
+
+import "testing"
+
+func TestOutboxRecord(t *testing.T) {
+	box := newOutbox()
+	box.record(newSendGroupMsg(1, "hello 192.168.1.20"))
+	box.record(&CQWSMessage{
+		Action: ActionSendGroupMsg,
+		Params: CQTypeSendGroupMsgSegments{
+			GroupID: 2,
+			Message: []MessageSegment{
+				NewSection("at", map[string]string{"qq": "1"}),
+				{Type: "text", Data: map[string]string{"text": "a,b"}},
+			},
+		},
+	})
+	box.record(&CQWSMessage{
+		Action: ActionSendMsg,
+		Params: CQTypeSendMsg{MessageType: MessageTypeGroup, GroupID: 3, Message: "hi"},
+	})
+	box.record(&CQWSMessage{
+		Action: ActionSendPrivateMsg,
+		Params: CQTypeSendPrivateMsg{UserID: 4, Message: "[CQ:face,id=1]"},
+	})
+	// 不是发送消息的动作不记录
+	box.record(&CQWSMessage{Action: ActionDeleteMsg, Params: CQTypeDeleteMsg{MessageID: 1}})
+	want := []OutboxEntry{
+		{Action: ActionSendGroupMsg, Target: 1, Text: "hello 192.*.*.20"},
+		{Action: ActionSendGroupMsg, Target: 2, Text: "[CQ:at,qq=1]a&#44;b"},
+		{Action: ActionSendMsg, Target: 3, Text: "hi"},
+		{Action: ActionSendPrivateMsg, Target: 4, Text: "[CQ:face,id=1]"},
+	}
+	got := box.list()
+	if len(got) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(got), len(want))
+	}
+	for i := range want {
+		got[i].Time = 0
+		if got[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestOutboxSize(t *testing.T) {
+	box := newOutbox()
+	box.setSize(3)
+	for i := int64(1); i <= 5; i++ {
+		box.record(newSendGroupMsg(i, "hi"))
+	}
+	got := box.list()
+	if len(got) != 3 || got[0].Target != 3 || got[2].Target != 5 {
+		t.Fatalf("outbox should keep the newest 3 messages, got %+v", got)
+	}
+	box.setSize(2)
+	if got := box.list(); len(got) != 2 || got[0].Target != 4 {
+		t.Fatalf("shrinking should keep the newest messages, got %+v", got)
+	}
+	box.setSize(-1)
+	box.record(newSendGroupMsg(6, "hi"))
+	if got := box.list(); len(got) != 0 {
+		t.Fatalf("disabled outbox should be empty, got %+v", got)
+	}
+}
@@ -1,5 +1,10 @@
 package coolq
 
+import (
+	"github.com/BurntSushi/toml"
+	"github.com/haruno-bot/haruno/logger"
+)
+
 var entries = []PluginInterface{}
 
 // PluginInterface 插件基础接口
@@ -7,12 +12,89 @@ var entries = []PluginInterface{}
 // 完成load会执行 Onload 钩子函数
 type PluginInterface interface {
 	Name() string
+	Version() string
+	Author() string
 	Load() error
 	Filters() map[string]Filter
 	Handlers() map[string]Handler
 	Loaded()
 }
 
+// MetaEventPlugin 可选的插件接口
+// 只有实现了这个接口并返回 true 的插件才会收到 meta_event 上报(比如心跳)
+type MetaEventPlugin interface {
+	AcceptMetaEvent() bool
+}
+
+// ContextPlugin 可选的插件接口
+// HandlersCtx 返回的处理函数和 Handlers 一样按key对应 Filters，调用时会传入一个 context
+// 适合需要请求外部服务等耗时较长的处理函数，在 ctx 取消时应该尽快返回
+type ContextPlugin interface {
+	HandlersCtx() map[string]HandlerCtx
+}
+
+// PluginMeta 插件的基本信息
+type PluginMeta struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Author  string `json:"author"`
+}
+
+// PluginStatus 插件的运行状态
+type PluginStatus struct {
+	PluginMeta
+	Filters  int      `json:"filters"`
+	Keys     []string `json:"keys"`
+	Handlers int      `json:"handlers"`
+	Enabled  bool     `json:"enabled"`
+}
+
+// PriorityPlugin 可选的插件接口
+// 插件按 Priority 从小到大的顺序处理事件，相同时按插件名排序
+// 没有实现这个接口的插件优先级为0
+type PriorityPlugin interface {
+	Priority() int
+}
+
+// LoggerPlugin 可选的插件接口
+// 加载插件之前会传入一个以插件名为域的logger
+type LoggerPlugin interface {
+	SetLogger(logger.LogInterface)
+}
+
+// PluginConfig 插件的配置，对应配置文件中的 [plugins."插件名"] 表
+type PluginConfig struct {
+	md   *toml.MetaData
+	prim toml.Primitive
+	ok   bool
+}
+
+// IsDefined 配置文件中是否有这个插件的配置
+func (cfg PluginConfig) IsDefined() bool {
+	return cfg.ok
+}
+
+// Decode 把插件的配置解析到v，没有配置时v保持不变
+func (cfg PluginConfig) Decode(v interface{}) error {
+	if !cfg.ok {
+		return nil
+	}
+	return cfg.md.PrimitiveDecode(cfg.prim, v)
+}
+
+// ConfigurablePlugin 可选的插件接口
+// 加载插件之前会传入插件自己的配置，返回错误时插件不会被加载
+type ConfigurablePlugin interface {
+	Configure(cfg PluginConfig) error
+}
+
+// UnloadPlugin 可选的插件接口
+// 机器人关闭时会调用 Unload 释放插件的资源(关闭数据库、停止定时器等)
+// 调用时酷q的websocket连接仍然可用，之后日志服务才会关闭
+type UnloadPlugin interface {
+	Unload() error
+}
+
 // PluginRegister 插件注册
 func PluginRegister(plugins ...PluginInterface) {
 	entries = append(entries, plugins...)
@@ -27,6 +109,16 @@ func (_plugin Plugin) Name() string {
 	return "__UNNAMED_PLUGIN__"
 }
 
+// Version 获取插件版本
+func (_plugin Plugin) Version() string {
+	return ""
+}
+
+// Author 获取插件作者
+func (_plugin Plugin) Author() string {
+	return ""
+}
+
 // Load 插件加载
 func (_plugin Plugin) Load() error {
 	return nil
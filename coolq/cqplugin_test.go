@@ -0,0 +1,106 @@
+package coolq
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/haruno-bot/haruno/logger"
+)
+
+// namedPlugin 只有名字的测试插件，记录 Load 是否被调用
+type namedPlugin struct {
+	Plugin
+	name   string
+	loaded bool
+}
+
+func (p *namedPlugin) Name() string {
+	return p.name
+}
+
+func (p *namedPlugin) Load() error {
+	p.loaded = true
+	return nil
+}
+
+// withEntries 在 fn 执行期间把注册的插件替换为 plugins
+func withEntries(plugins []PluginInterface, fn func()) {
+	saved := entries
+	entries = plugins
+	defer func() {
+		entries = saved
+	}()
+	fn()
+}
+
+func TestRegisterDuplicatePluginName(t *testing.T) {
+	c := newTestClient(t, nil)
+	defer c.Close()
+	first := &namedPlugin{name: "echo"}
+	second := &namedPlugin{name: "echo"}
+	other := &namedPlugin{name: "other"}
+	withEntries(nil, func() {
+		PluginRegister(first, second, other)
+		c.RegisterAllPlugins()
+	})
+	if !first.loaded || second.loaded || !other.loaded {
+		t.Fatalf("loaded: first %v, second %v, other %v; only the second should be skipped", first.loaded, second.loaded, other.loaded)
+	}
+	if len(c.plugins) != 2 || c.plugins[0] != first || c.plugins[1] != other {
+		t.Fatalf("registered plugins = %v", c.plugins)
+	}
+	found := false
+	for _, lg := range logger.Service.Recent() {
+		if lg.Type == logger.LogTypeError && strings.Contains(lg.Text, "another plugin with the same name") {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("duplicated plugin name is not reported as an error")
+	}
+}
+
+// misconfiguredPlugin 有一个没有handler的filter，并且同一个key同时有 Handler 和 HandlerCtx
+type misconfiguredPlugin struct {
+	Plugin
+}
+
+func (misconfiguredPlugin) Name() string {
+	return "misconfigured"
+}
+
+func (misconfiguredPlugin) Filters() map[string]Filter {
+	pass := func(*CQEvent) bool { return true }
+	return map[string]Filter{"unused": pass, "both": pass}
+}
+
+func (misconfiguredPlugin) Handlers() map[string]Handler {
+	return map[string]Handler{"both": func(*CQEvent) {}, "all": func(*CQEvent) {}}
+}
+
+func (misconfiguredPlugin) HandlersCtx() map[string]HandlerCtx {
+	return map[string]HandlerCtx{"both": func(context.Context, *CQEvent) {}}
+}
+
+func TestRegisterReportsMisconfiguredKeys(t *testing.T) {
+	c := newTestClient(t, nil)
+	defer c.Close()
+	withEntries(nil, func() {
+		PluginRegister(misconfiguredPlugin{})
+		c.RegisterAllPlugins()
+	})
+	want := map[string]int{
+		"misconfigured: 存在没有使用的key: unused，它没有对应的handler":                  logger.LogTypeError,
+		"misconfigured: key: both 同时存在 Handler 和 HandlerCtx，使用 HandlerCtx": logger.LogTypeError,
+		"misconfigured: 1 个handler没有对应的filter，会处理所有的事件":                    logger.LogTypeInfo,
+	}
+	for _, lg := range logger.Service.Recent() {
+		if typ, ok := want[lg.Text]; ok && lg.Type == typ {
+			delete(want, lg.Text)
+		}
+	}
+	for text := range want {
+		t.Errorf("log %q is not found", text)
+	}
+}
@@ -0,0 +1,169 @@
+package coolq
+
+import (
+	"errors"
+	"hash/fnv"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/haruno-bot/haruno/logger"
+)
+
+// jobQueueSize 每个worker的任务队列长度
+// 提交上报事件时不会等待，队列要能放下重连之后一次性收到的大量上报
+const jobQueueSize = 1024
+
+// jobQueueTimeout chain 在任务队列已满时最多等待的时间，超时的任务会被丢弃
+var jobQueueTimeout = 5 * time.Second
+
+// 提交任务失败的原因
+var (
+	errPoolStopped = errors.New("worker pool is stopped")
+	errQueueFull   = errors.New("worker queue is full")
+)
+
+// workerPool 处理上报事件的有界协程池
+// 同一个key(插件名)的任务总是交给同一个worker，以保证处理顺序
+// 每个worker有两个队列，chained 保存由其他插件交过来的任务，优先处理
+type workerPool struct {
+	// pending 已经提交但是还没有执行完的任务数
+	pending int64
+	// dropped 因为队列已满被丢弃的任务数
+	dropped int64
+	stopped int32
+	jobs    []chan func()
+	chained []chan func()
+	// quit 关闭之后所有的worker退出
+	quit     chan struct{}
+	quitOnce sync.Once
+}
+
+// newWorkerPool 创建协程池，size <= 0 时使用 runtime.NumCPU()
+func newWorkerPool(size int) *workerPool {
+	if size <= 0 {
+		size = runtime.NumCPU()
+	}
+	pool := &workerPool{
+		jobs:    make([]chan func(), size),
+		chained: make([]chan func(), size),
+		quit:    make(chan struct{}),
+	}
+	for i := range pool.jobs {
+		pool.jobs[i] = make(chan func(), jobQueueSize)
+		pool.chained[i] = make(chan func(), jobQueueSize)
+		go pool.work(pool.jobs[i], pool.chained[i])
+	}
+	return pool
+}
+
+// work 执行任务，先处理完 chained 中的任务再接受新的事件
+// 这样worker交给自己的后续任务不会因为队列被新事件占满而等待
+func (pool *workerPool) work(jobs, chained chan func()) {
+	for {
+		select {
+		case job := <-chained:
+			pool.run(job)
+			continue
+		default:
+		}
+		select {
+		case <-pool.quit:
+			return
+		case job := <-chained:
+			pool.run(job)
+		case job := <-jobs:
+			pool.run(job)
+		}
+	}
+}
+
+// run 执行一个任务，防止插件的panic影响到worker
+func (pool *workerPool) run(job func()) {
+	defer atomic.AddInt64(&pool.pending, -1)
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Errorf("plugin handler panic: %v", err)
+		}
+	}()
+	job()
+}
+
+// worker key对应的worker序号
+func (pool *workerPool) worker(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(pool.jobs)))
+}
+
+// submit 提交一个任务，key相同的任务按提交顺序执行
+// 在读取上报的协程中调用，不能阻塞，队列已满时直接丢弃并返回 errQueueFull
+// 协程池停止之后提交的任务也会被丢弃
+func (pool *workerPool) submit(key string, job func()) error {
+	if atomic.LoadInt32(&pool.stopped) == 1 {
+		return errPoolStopped
+	}
+	return pool.enqueue(pool.jobs[pool.worker(key)], job, 0)
+}
+
+// chain 在worker内部提交后续的任务
+// 队列已满时同样最多等待 jobQueueTimeout，不会改变任务的顺序
+// 已经开始处理的事件在协程池停止之后仍然会继续交给后面的插件
+func (pool *workerPool) chain(key string, job func()) error {
+	return pool.enqueue(pool.chained[pool.worker(key)], job, jobQueueTimeout)
+}
+
+// enqueue 把任务放入队列，队列已满时最多等待 wait，仍然放不进去时丢弃
+// 丢弃的任务由调用方给出警告
+func (pool *workerPool) enqueue(queue chan func(), job func(), wait time.Duration) error {
+	atomic.AddInt64(&pool.pending, 1)
+	select {
+	case queue <- job:
+		return nil
+	default:
+	}
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case queue <- job:
+			return nil
+		case <-pool.quit:
+			atomic.AddInt64(&pool.pending, -1)
+			return errPoolStopped
+		case <-timer.C:
+		}
+	}
+	atomic.AddInt64(&pool.pending, -1)
+	atomic.AddInt64(&pool.dropped, 1)
+	return errQueueFull
+}
+
+// droppedCount 因为队列已满被丢弃的任务总数
+func (pool *workerPool) droppedCount() int64 {
+	return atomic.LoadInt64(&pool.dropped)
+}
+
+// drain 停止接受新的任务，并等待已经提交的任务执行完
+// 超过 timeout 时返回仍未执行完的任务数
+func (pool *workerPool) drain(timeout time.Duration) int64 {
+	atomic.StoreInt32(&pool.stopped, 1)
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if atomic.LoadInt64(&pool.pending) == 0 {
+			return 0
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return atomic.LoadInt64(&pool.pending)
+}
+
+// stop 停止所有的worker，还在队列中的任务不会再执行
+// 应该在 drain 之后调用
+func (pool *workerPool) stop() {
+	atomic.StoreInt32(&pool.stopped, 1)
+	pool.quitOnce.Do(func() {
+		close(pool.quit)
+	})
+}
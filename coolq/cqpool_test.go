@@ -0,0 +1,106 @@
+package coolq
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPoolChainKeepsOrder(t *testing.T) {
+	for _, workers := range []int{1, 4} {
+		pool := newWorkerPool(workers)
+		const total = 500
+		var mu sync.Mutex
+		got := make([]int, 0, total)
+		var wg sync.WaitGroup
+		wg.Add(total)
+		for i := 0; i < total; i++ {
+			i := i
+			// 第一个插件处理完之后交给第二个插件，第二个插件收到的顺序应该和提交的顺序相同
+			err := pool.submit("first", func() {
+				pool.chain("second", func() {
+					mu.Lock()
+					got = append(got, i)
+					mu.Unlock()
+					wg.Done()
+				})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		wg.Wait()
+		for j, i := range got {
+			if i != j {
+				t.Fatalf("workers = %d, job %d runs at position %d", workers, i, j)
+			}
+		}
+		if pending := pool.drain(time.Second); pending != 0 {
+			t.Errorf("workers = %d, %d jobs are still pending", workers, pending)
+		}
+	}
+}
+
+func TestPoolDropsWhenFull(t *testing.T) {
+	pool := newWorkerPool(1)
+	block := make(chan struct{})
+	pool.submit("key", func() {
+		<-block
+	})
+	var ran int32
+	dropped := 0
+	start := time.Now()
+	for i := 0; i < jobQueueSize+10; i++ {
+		if err := pool.submit("key", func() { atomic.AddInt32(&ran, 1) }); err == errQueueFull {
+			dropped++
+		}
+	}
+	// 队列已满时 submit 不等待
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("submit to a full queue blocks for %v", elapsed)
+	}
+	close(block)
+	if dropped == 0 || pool.droppedCount() != int64(dropped) {
+		t.Fatalf("dropped %d jobs, counter is %d", dropped, pool.droppedCount())
+	}
+	if pending := pool.drain(time.Second); pending != 0 {
+		t.Fatalf("%d jobs are still pending", pending)
+	}
+	if int(ran)+dropped != jobQueueSize+10 {
+		t.Errorf("%d jobs ran and %d are dropped, some jobs are lost", ran, dropped)
+	}
+	if err := pool.submit("key", func() {}); err != errPoolStopped {
+		t.Errorf("submit after drain should fail, got %v", err)
+	}
+}
+
+func TestPoolChainWaitsWhenFull(t *testing.T) {
+	timeout := jobQueueTimeout
+	jobQueueTimeout = 50 * time.Millisecond
+	defer func() {
+		jobQueueTimeout = timeout
+	}()
+	pool := newWorkerPool(1)
+	defer pool.stop()
+	block := make(chan struct{})
+	defer close(block)
+	started := make(chan struct{})
+	pool.submit("key", func() {
+		close(started)
+		<-block
+	})
+	<-started
+	for i := 0; i < jobQueueSize; i++ {
+		if err := pool.chain("key", func() {}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now()
+	if err := pool.chain("key", func() {}); err != errQueueFull {
+		t.Fatalf("chain to a full queue should fail, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < jobQueueTimeout {
+		t.Errorf("chain gives up after %v, want at least %v", elapsed, jobQueueTimeout)
+	}
+}
@@ -0,0 +1,94 @@
+package coolq
+
+import (
+	"sync"
+	"time"
+)
+
+// 断线时发送队列的默认长度和消息的默认有效期
+const (
+	defaultSendQueueSize = 100
+	defaultSendQueueTTL  = 60 * time.Second
+)
+
+// pendingSend 等待发送的消息
+type pendingSend struct {
+	payload *CQWSMessage
+	at      time.Time
+}
+
+// expired 消息在队列中是否已经超过有效期
+func (p pendingSend) expired(ttl time.Duration) bool {
+	return time.Since(p.at) > ttl
+}
+
+// sendQueue api服务断开时缓存待发送的消息
+// 连接恢复后按顺序重新发送
+type sendQueue struct {
+	mu    sync.Mutex
+	items []pendingSend
+	size  int
+	ttl   time.Duration
+}
+
+func newSendQueue() *sendQueue {
+	return &sendQueue{
+		items: make([]pendingSend, 0),
+		size:  defaultSendQueueSize,
+		ttl:   defaultSendQueueTTL,
+	}
+}
+
+// setSize 设置队列长度，size < 0 时不缓存，size == 0 时使用默认值
+func (q *sendQueue) setSize(size int) {
+	if size == 0 {
+		size = defaultSendQueueSize
+	}
+	q.mu.Lock()
+	q.size = size
+	q.mu.Unlock()
+}
+
+// setTTL 设置消息有效期，ttl <= 0 时使用默认值
+func (q *sendQueue) setTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = defaultSendQueueTTL
+	}
+	q.mu.Lock()
+	q.ttl = ttl
+	q.mu.Unlock()
+}
+
+// disabled 是否关闭了发送队列
+func (q *sendQueue) disabled() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size < 0
+}
+
+// push 把消息加入队列，队列已满时返回 false
+func (q *sendQueue) push(payload *CQWSMessage) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) >= q.size {
+		return false
+	}
+	q.items = append(q.items, pendingSend{payload: payload, at: time.Now()})
+	return true
+}
+
+// requeue 把没有发送成功的消息放回队列头部
+func (q *sendQueue) requeue(items []pendingSend) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.items = append(items, q.items...)
+}
+
+// drain 取出队列中的全部消息以及当前的有效期
+func (q *sendQueue) drain() ([]pendingSend, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items := q.items
+	q.items = make([]pendingSend, 0)
+	return items, q.ttl
+}
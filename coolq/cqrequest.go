@@ -0,0 +1,79 @@
+package coolq
+
+import "github.com/haruno-bot/haruno/logger"
+
+// 请求类型
+const (
+	// RequestTypeFriend 加好友请求
+	RequestTypeFriend = "friend"
+	// RequestTypeGroup 加群请求或者邀请
+	RequestTypeGroup = "group"
+)
+
+// SubTypeAdd 加群请求的子类型，邀请机器人入群的子类型为 SubTypeInvite
+const SubTypeAdd = "add"
+
+// CQRequest 请求上报
+// 加好友请求的 GroupID 为0，SubType 为空
+// 加群请求的 SubType 为 add(有人申请入群) 或 invite(有人邀请机器人入群)
+type CQRequest struct {
+	RequestType string
+	SubType     string
+	Time        int64
+	SelfID      int64
+	UserID      int64
+	GroupID     int64
+	Comment     string
+	Flag        string
+}
+
+// AsRequest 把请求上报转换成 CQRequest
+// 不是请求上报时返回 false
+func (event *CQEvent) AsRequest() (*CQRequest, bool) {
+	if event.PostType != PostTypeRequest {
+		return nil, false
+	}
+	return &CQRequest{
+		RequestType: event.RequestType,
+		SubType:     event.SubType,
+		Time:        event.Time,
+		SelfID:      event.SelfID,
+		UserID:      event.UserID,
+		GroupID:     event.GroupID,
+		Comment:     event.Comment,
+		Flag:        event.Flag,
+	}, true
+}
+
+// IsFriend 是否是加好友请求
+func (req *CQRequest) IsFriend() bool {
+	return req.RequestType == RequestTypeFriend
+}
+
+// IsGroup 是否是加群请求或者邀请
+func (req *CQRequest) IsGroup() bool {
+	return req.RequestType == RequestTypeGroup
+}
+
+// Approve 同意这个请求
+func (req *CQRequest) Approve() {
+	req.reply(true, "")
+}
+
+// Reject 拒绝这个请求
+// reason 为拒绝的理由，只对加群请求有效
+func (req *CQRequest) Reject(reason string) {
+	req.reply(false, reason)
+}
+
+// reply 根据请求类型调用 SetFriendAddRequest 或者 SetGroupAddRequest
+func (req *CQRequest) reply(approve bool, reason string) {
+	switch req.RequestType {
+	case RequestTypeFriend:
+		Client.SetFriendAddRequest(req.Flag, approve, "")
+	case RequestTypeGroup:
+		Client.SetGroupAddRequest(req.Flag, req.SubType, approve, reason)
+	default:
+		logger.Logger.Warnf("unknown request type %q, request %s is ignored\n", req.RequestType, req.Flag)
+	}
+}
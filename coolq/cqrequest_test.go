@@ -0,0 +1,118 @@
+package coolq
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAsRequest(t *testing.T) {
+	cases := []struct {
+		raw    string
+		want   CQRequest
+		friend bool
+	}{
+		{
+			`{"post_type":"request","request_type":"friend","time":1,"self_id":10,"user_id":2,"comment":"hi","flag":"f1"}`,
+			CQRequest{RequestType: RequestTypeFriend, Time: 1, SelfID: 10, UserID: 2, Comment: "hi", Flag: "f1"},
+			true,
+		},
+		{
+			`{"post_type":"request","request_type":"group","sub_type":"add","time":1,"self_id":10,"group_id":100,"user_id":2,"comment":"let me in","flag":"f2"}`,
+			CQRequest{RequestType: RequestTypeGroup, SubType: SubTypeAdd, Time: 1, SelfID: 10, UserID: 2, GroupID: 100, Comment: "let me in", Flag: "f2"},
+			false,
+		},
+		{
+			`{"post_type":"request","request_type":"group","sub_type":"invite","time":1,"self_id":10,"group_id":100,"user_id":2,"comment":"","flag":"f3"}`,
+			CQRequest{RequestType: RequestTypeGroup, SubType: SubTypeInvite, Time: 1, SelfID: 10, UserID: 2, GroupID: 100, Flag: "f3"},
+			false,
+		},
+	}
+	for _, c := range cases {
+		req, ok := decodeEvent(t, c.raw).AsRequest()
+		if !ok {
+			t.Errorf("%s is not a request", c.raw)
+			continue
+		}
+		if !reflect.DeepEqual(*req, c.want) {
+			t.Errorf("AsRequest(%s) = %+v, want %+v", c.raw, *req, c.want)
+		}
+		if req.IsFriend() != c.friend || req.IsGroup() == c.friend {
+			t.Errorf("%s: IsFriend = %v, IsGroup = %v", c.raw, req.IsFriend(), req.IsGroup())
+		}
+	}
+	if req, ok := decodeEvent(t, `{"post_type":"notice","notice_type":"friend_add","user_id":2}`).AsRequest(); ok || req != nil {
+		t.Errorf("notice event should not be a request, got %+v", req)
+	}
+}
+
+// apiRequest 收到的一次 http api 请求
+type apiRequest struct {
+	path   string
+	params map[string]interface{}
+}
+
+func TestRequestReply(t *testing.T) {
+	requests := make(chan apiRequest, 8)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		params := make(map[string]interface{})
+		json.Unmarshal(body, &params)
+		requests <- apiRequest{r.URL.Path, params}
+		w.Write([]byte(`{"status":"ok","retcode":0,"data":null}`))
+	}))
+	defer srv.Close()
+	c := newTestClient(t, func(c *cqclient) {
+		c.SetAPITransport(APITransportHTTP)
+	})
+	c.SetHTTPURL(srv.URL)
+	saved := Client
+	Client = c
+	defer func() {
+		Client = saved
+	}()
+	cases := []struct {
+		req  CQRequest
+		act  func(req *CQRequest)
+		want apiRequest
+	}{
+		{
+			CQRequest{RequestType: RequestTypeFriend, Flag: "f1"},
+			(*CQRequest).Approve,
+			apiRequest{"/" + ActionSetFriendAddRequest, map[string]interface{}{"flag": "f1", "approve": true, "remark": ""}},
+		},
+		{
+			CQRequest{RequestType: RequestTypeGroup, SubType: SubTypeAdd, Flag: "f2"},
+			func(req *CQRequest) { req.Reject("no") },
+			apiRequest{"/" + ActionSetGroupAddRequest, map[string]interface{}{"flag": "f2", "sub_type": "add", "approve": false, "reason": "no"}},
+		},
+		{
+			CQRequest{RequestType: RequestTypeGroup, SubType: SubTypeInvite, Flag: "f3"},
+			(*CQRequest).Approve,
+			apiRequest{"/" + ActionSetGroupAddRequest, map[string]interface{}{"flag": "f3", "sub_type": "invite", "approve": true, "reason": ""}},
+		},
+	}
+	for _, tc := range cases {
+		req := tc.req
+		tc.act(&req)
+		select {
+		case got := <-requests:
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("reply to %+v sent %+v, want %+v", tc.req, got, tc.want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("reply to %+v is not sent", tc.req)
+		}
+	}
+	// 不认识的请求类型不会发送任何请求
+	(&CQRequest{RequestType: "unknown", Flag: "f4"}).Approve()
+	select {
+	case got := <-requests:
+		t.Errorf("unknown request type sent %+v", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
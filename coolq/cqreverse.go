@@ -0,0 +1,106 @@
+package coolq
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/haruno-bot/haruno/logger"
+)
+
+// 反向websocket连接的角色，由 X-Client-Role 请求头给出
+const (
+	clientRoleAPI       = "API"
+	clientRoleEvent     = "Event"
+	clientRoleUniversal = "Universal"
+)
+
+var reverseUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// isEventPayload 消息是否是上报事件
+func isEventPayload(raw []byte) bool {
+	var payload struct {
+		PostType string `json:"post_type"`
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return false
+	}
+	return payload.PostType != ""
+}
+
+// checkReverseToken 检查反向websocket连接的access token
+// 同时支持 "Token xxx" 和 "Bearer xxx" 两种格式
+func (c *cqclient) checkReverseToken(r *http.Request) bool {
+	if c.token == "" {
+		return true
+	}
+	auth := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(strings.TrimPrefix(auth, "Token "), "Bearer ")
+	if token == "" {
+		token = r.URL.Query().Get("access_token")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(c.token)) == 1
+}
+
+// SetHTTPURL 设置酷q http api的地址
+// 使用反向websocket时不会调用 Connect，需要单独设置
+func (c *cqclient) SetHTTPURL(httpURL string) {
+	c.setAPIURL(httpURL)
+}
+
+// ReverseHandler 接受酷q反向websocket连接的处理函数
+// 根据 X-Client-Role 把连接作为api连接、event连接或者同时作为两者使用
+// 需要在 Initialize 之后使用
+func (c *cqclient) ReverseHandler(w http.ResponseWriter, r *http.Request) {
+	if !c.checkReverseToken(r) {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	role := r.Header.Get("X-Client-Role")
+	if role == "" {
+		role = clientRoleUniversal
+	}
+	if role != clientRoleAPI && role != clientRoleEvent && role != clientRoleUniversal {
+		http.Error(w, "unknown client role", http.StatusBadRequest)
+		return
+	}
+	conn, err := reverseUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Field("coolq reverse conn").Errorf("failed to upgrade connection: %v", err)
+		return
+	}
+	switch role {
+	case clientRoleAPI:
+		c.setUniversal(false)
+		c.apiConn.Accept(conn)
+	case clientRoleEvent:
+		c.setUniversal(false)
+		c.eventConn.Accept(conn)
+	default:
+		c.setUniversal(true)
+		c.apiConn.Accept(conn)
+	}
+}
+
+// setUniversal 设置是否使用 Universal 连接
+// 在http处理协程中调用，和读取的协程不同，所以使用atomic操作
+func (c *cqclient) setUniversal(universal bool) {
+	var v int32
+	if universal {
+		v = 1
+	}
+	atomic.StoreInt32(&c.universal, v)
+}
+
+// isUniversal 是否使用 Universal 连接同时作为api连接和event连接
+func (c *cqclient) isUniversal() bool {
+	return atomic.LoadInt32(&c.universal) == 1
+}
@@ -0,0 +1,60 @@
+package coolq
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestReverseUniversalConn(t *testing.T) {
+	c := newTestClient(t, nil)
+	defer c.Close()
+	srv := httptest.NewServer(http.HandlerFunc(c.ReverseHandler))
+	defer srv.Close()
+	// 连接建立的同时读取状态，配合 -race 检查数据竞争
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			c.IsEventOk()
+		}
+	}()
+	headers := http.Header{}
+	headers.Set("Authorization", "Token token")
+	headers.Set("X-Client-Role", clientRoleUniversal)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	<-done
+	deadline := time.Now().Add(2 * time.Second)
+	for !c.IsEventOk() {
+		if time.Now().After(deadline) {
+			t.Fatal("universal connection should serve events")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !c.isUniversal() {
+		t.Error("connection without role should be universal")
+	}
+}
+
+func TestReverseRejectsBadToken(t *testing.T) {
+	c := newTestClient(t, nil)
+	srv := httptest.NewServer(http.HandlerFunc(c.ReverseHandler))
+	defer srv.Close()
+	headers := http.Header{}
+	headers.Set("Authorization", "Token wrong")
+	_, res, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), headers)
+	if err == nil {
+		t.Fatal("connection with wrong token should be rejected")
+	}
+	if res == nil || res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("got response %v, want 401", res)
+	}
+}
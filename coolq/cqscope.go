@@ -0,0 +1,42 @@
+package coolq
+
+// onPostType 生成一对只匹配 postType 类型上报的 Filter 和 Handler
+func onPostType(postType string, handler Handler) (Filter, Handler) {
+	filter := func(event *CQEvent) bool {
+		return event.PostType == postType
+	}
+	return filter, handler
+}
+
+// OnMessage 生成一对只处理消息上报的 Filter 和 Handler
+func OnMessage(handler Handler) (Filter, Handler) {
+	return onPostType(PostTypeMessage, handler)
+}
+
+// OnNotice 生成一对只处理通知上报的 Filter 和 Handler
+func OnNotice(handler Handler) (Filter, Handler) {
+	return onPostType(PostTypeNotice, handler)
+}
+
+// OnRequest 生成一对只处理请求上报的 Filter 和 Handler
+func OnRequest(handler Handler) (Filter, Handler) {
+	return onPostType(PostTypeRequest, handler)
+}
+
+// AtMeFilter 生成一个只匹配@了机器人的消息的 Filter
+// 私聊消息总是匹配，机器人的QQ号优先使用 GetLoginInfo 缓存的结果
+func AtMeFilter() Filter {
+	return func(event *CQEvent) bool {
+		if event.PostType != PostTypeMessage {
+			return false
+		}
+		if event.MessageType == MessageTypePrivate {
+			return true
+		}
+		selfID := Client.SelfID()
+		if selfID == 0 {
+			selfID = event.SelfID
+		}
+		return event.IsAtMe(selfID)
+	}
+}
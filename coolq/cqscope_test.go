@@ -0,0 +1,46 @@
+package coolq
+
+import "testing"
+
+func TestAtMeFilter(t *testing.T) {
+	cases := []struct {
+		name  string
+		raw   string
+		match bool
+	}{
+		{"group message at me", `{"post_type":"message","message_type":"group","group_id":100,"user_id":2,"self_id":1,"message":"[CQ:at,qq=1] hello"}`, true},
+		{"group message at me in array format", `{"post_type":"message","message_type":"group","group_id":100,"user_id":2,"self_id":1,"message":[{"type":"at","data":{"qq":"1"}},{"type":"text","data":{"text":" hello"}}]}`, true},
+		{"group message at someone else", `{"post_type":"message","message_type":"group","group_id":100,"user_id":2,"self_id":1,"message":"[CQ:at,qq=3] hello"}`, false},
+		{"group message without at", `{"post_type":"message","message_type":"group","group_id":100,"user_id":2,"self_id":1,"message":"hello 1"}`, false},
+		{"private message", `{"post_type":"message","message_type":"private","user_id":2,"self_id":1,"message":"hello"}`, true},
+		{"notice", `{"post_type":"notice","notice_type":"group_increase","group_id":100,"user_id":1,"self_id":1}`, false},
+	}
+	filter := AtMeFilter()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := filter(decodeEvent(t, tc.raw)); got != tc.match {
+				t.Errorf("AtMeFilter() = %v, want %v", got, tc.match)
+			}
+		})
+	}
+}
+
+func TestAtMeFilterPrefersLoginInfo(t *testing.T) {
+	Client.mu.Lock()
+	old := Client.loginInfo
+	Client.loginInfo = &CQTypeGetLoginInfo{UserID: 10}
+	Client.mu.Unlock()
+	defer func() {
+		Client.mu.Lock()
+		Client.loginInfo = old
+		Client.mu.Unlock()
+	}()
+	filter := AtMeFilter()
+	// 缓存的QQ号优先于事件中的 self_id
+	if !filter(decodeEvent(t, `{"post_type":"message","message_type":"group","group_id":100,"user_id":2,"self_id":1,"message":"[CQ:at,qq=10] hi"}`)) {
+		t.Error("message at the cached self id should match")
+	}
+	if filter(decodeEvent(t, `{"post_type":"message","message_type":"group","group_id":100,"user_id":2,"self_id":1,"message":"[CQ:at,qq=1] hi"}`)) {
+		t.Error("message at the event self id should not match when login info is cached")
+	}
+}
@@ -0,0 +1,90 @@
+package coolq
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// entityPattern 转义后的字符，如 &#91; 和 &amp;
+var entityPattern = regexp.MustCompile(`&(?:amp|#91|#93|#44);`)
+
+// splitMessage 把超过 limit 个字符的消息切分成多条
+// cq码和转义字符不会被切开，单个超过 limit 的cq码单独作为一条
+// 尽量在换行处切分，limit <= 0 时不切分
+func splitMessage(message string, limit int) []string {
+	if limit <= 0 || utf8.RuneCountInString(message) <= limit {
+		return []string{message}
+	}
+	chunks := make([]string, 0)
+	cur := new(strings.Builder)
+	curLen := 0
+	// breakAt 当前切片中最后一个换行之后的位置
+	breakAt, breakLen := 0, 0
+	flush := func() {
+		text := cur.String()
+		rest, restLen := "", 0
+		// 换行在后半段时从换行处切开，剩下的部分留到下一条
+		if breakAt > 0 && breakLen*2 >= limit && breakAt < len(text) {
+			text, rest = text[:breakAt], text[breakAt:]
+			restLen = curLen - breakLen
+		}
+		if text = strings.TrimRight(text, "\r\n"); text != "" {
+			chunks = append(chunks, text)
+		}
+		cur.Reset()
+		cur.WriteString(rest)
+		curLen = restLen
+		breakAt, breakLen = 0, 0
+	}
+	for _, atom := range messageAtoms(message) {
+		// 转义字符实际只显示一个字符
+		n := 1
+		if !entityPattern.MatchString(atom) {
+			n = utf8.RuneCountInString(atom)
+		}
+		if curLen > 0 && curLen+n > limit {
+			flush()
+			// 换行之后剩下的部分加上这个单元仍然超长
+			if curLen > 0 && curLen+n > limit {
+				flush()
+			}
+		}
+		cur.WriteString(atom)
+		curLen += n
+		if atom == "\n" {
+			breakAt, breakLen = cur.Len(), curLen
+		}
+	}
+	if curLen > 0 {
+		flush()
+	}
+	return chunks
+}
+
+// messageAtoms 把消息拆成不能再切开的单元
+// 每个cq码、每个转义字符和每个普通字符各为一个单元
+func messageAtoms(message string) []string {
+	atoms := make([]string, 0, len(message))
+	addText := func(text string) {
+		last := 0
+		for _, loc := range entityPattern.FindAllStringIndex(text, -1) {
+			for _, r := range text[last:loc[0]] {
+				atoms = append(atoms, string(r))
+			}
+			atoms = append(atoms, text[loc[0]:loc[1]])
+			last = loc[1]
+		}
+		for _, r := range text[last:] {
+			atoms = append(atoms, string(r))
+		}
+	}
+	last := 0
+	for _, loc := range cqCodePattern.FindAllStringIndex(message, -1) {
+		addText(message[last:loc[0]])
+		atoms = append(atoms, message[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	addText(message[last:])
+	return atoms
+}
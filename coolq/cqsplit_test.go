@@ -0,0 +1,59 @@
+package coolq
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitMessage(t *testing.T) {
+	image := CQImage("1.png") // 21 个字符
+	cases := []struct {
+		message string
+		limit   int
+		want    []string
+	}{
+		{"abcdef", 0, []string{"abcdef"}},
+		{"abcdef", -1, []string{"abcdef"}},
+		{"abc", 3, []string{"abc"}},
+		{"abcdef", 3, []string{"abc", "def"}},
+		{"你好世界！", 2, []string{"你好", "世界", "！"}},
+		// cq码刚好放得下
+		{"abc" + image + "de", 25, []string{"abc" + image + "d", "e"}},
+		// cq码跨过边界时整个放到下一条
+		{"abcde" + image, 25, []string{"abcde", image}},
+		// 单个超长的cq码单独作为一条
+		{"ab" + image + "cd", 20, []string{"ab", image, "cd"}},
+		{image + image, 21, []string{image, image}},
+		// 转义字符不会被切开，并且只算一个字符
+		{"ab&#91;cd", 3, []string{"ab&#91;", "cd"}},
+		{"a&amp;&#44;&#93;b", 4, []string{"a&amp;&#44;&#93;", "b"}},
+		// 尽量在换行处切分
+		{"aaaa\nbbb", 6, []string{"aaaa", "bbb"}},
+		{"a\nbbbbbbb", 6, []string{"a\nbbbb", "bbb"}},
+	}
+	for _, c := range cases {
+		if got := splitMessage(c.message, c.limit); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("splitMessage(%q, %d) = %q, want %q", c.message, c.limit, got, c.want)
+		}
+	}
+}
+
+func TestSplitMessageKeepsContent(t *testing.T) {
+	message := strings.Repeat("hello, "+CQAt(123)+CQFace(1)+"&#91;world&#93;", 50)
+	for _, limit := range []int{1, 7, 30, 100, 1000} {
+		chunks := splitMessage(message, limit)
+		if joined := strings.Join(chunks, ""); joined != message {
+			t.Fatalf("limit %d: joined chunks differ from the message", limit)
+		}
+		// 被切开的cq码或者转义字符会被解析成文本
+		for _, chunk := range chunks {
+			for _, segment := range ParseMessage(chunk) {
+				text := segment.Data["text"]
+				if segment.Type == "text" && (strings.Contains(text, "[CQ:") || strings.Contains(text, "&")) {
+					t.Fatalf("limit %d: chunk %q is split inside a cq code or an entity", limit, chunk)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package coolq
+
+import (
+	"time"
+
+	"github.com/haruno-bot/haruno/logger"
+)
+
+// maxStatusFailures get_status 连续失败这么多次之后认为连接已经不可用
+const maxStatusFailures = 3
+
+// SetStatusPollInterval 设置定时检查 get_status 的间隔
+// 需要在 Initialize 之前调用，interval <= 0 时不检查
+func (c *cqclient) SetStatusPollInterval(interval time.Duration) {
+	c.statusInterval = interval
+}
+
+// SetReconnectOnOffline 设置检查到QQ离线或者 get_status 连续失败时是否重连event服务
+// 需要在 Initialize 之前调用
+func (c *cqclient) SetReconnectOnOffline(reconnect bool) {
+	c.reconnectOffline = reconnect
+}
+
+// getStatus 通过当前的api发送方式获取插件运行状态
+func (c *cqclient) getStatus() (*CQTypeGetStatus, error) {
+	payload := &CQWSMessage{
+		Action: ActionGetStatus,
+		Params: struct{}{},
+		Echo:   nextEcho(),
+	}
+	res, err := c.apiCall(payload)
+	if err != nil {
+		return nil, err
+	}
+	status := new(CQTypeGetStatus)
+	if err := decodeData(res, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// pollStatus 定时检查QQ是否在线，直到客户端关闭
+// 连接正常但是QQ已经掉线时只有这里能发现
+func (c *cqclient) pollStatus() {
+	ticker := time.NewTicker(c.statusInterval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !c.IsAPIOk() {
+			continue
+		}
+		status, err := c.getStatus()
+		if err != nil {
+			failures++
+			logger.Errorf("cqclient get_status error: %v", err)
+			if failures < maxStatusFailures {
+				continue
+			}
+			logger.Errorf("cqclient get_status has failed %d times in a row", failures)
+		} else if !status.Online {
+			logger.Errorf("QQ account is offline, please check the coolq client")
+		} else {
+			failures = 0
+			continue
+		}
+		failures = 0
+		if c.reconnectOffline {
+			c.eventConn.Reconnect()
+		}
+	}
+}
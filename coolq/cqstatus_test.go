@@ -0,0 +1,41 @@
+package coolq
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStatusPollingStopsOnDrain(t *testing.T) {
+	polls := new(int32)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/"+ActionGetStatus {
+			atomic.AddInt32(polls, 1)
+		}
+		w.Write([]byte(`{"status":"ok","retcode":0,"data":{"online":true,"good":true}}`))
+	}))
+	defer srv.Close()
+	c := newTestClient(t, func(c *cqclient) {
+		c.SetAPITransport(APITransportHTTP)
+		c.SetStatusPollInterval(10 * time.Millisecond)
+	})
+	defer c.Close()
+	c.SetHTTPURL(srv.URL)
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(polls) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if atomic.LoadInt32(polls) < 2 {
+		t.Fatal("get_status is not polled")
+	}
+	c.Drain(time.Second)
+	// 已经发出的请求可能还会完成
+	time.Sleep(50 * time.Millisecond)
+	stopped := atomic.LoadInt32(polls)
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(polls); n != stopped {
+		t.Errorf("get_status is still polled after drain: %d -> %d", stopped, n)
+	}
+}
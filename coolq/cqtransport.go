@@ -0,0 +1,111 @@
+package coolq
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/haruno-bot/haruno/logger"
+)
+
+// api请求的发送方式
+const (
+	// APITransportWebsocket 通过 websocket /api 连接发送
+	APITransportWebsocket = "websocket"
+	// APITransportHTTP 通过 http api 发送
+	APITransportHTTP = "http"
+)
+
+// apiTransport 发送api请求的方式
+type apiTransport interface {
+	// available 当前是否可以发送
+	available() bool
+	// send 发送api请求，返回的管道会在收到响应时被写入
+	send(payload *CQWSMessage) (chan *CQResponse, error)
+}
+
+// wsTransport 通过 websocket 发送api请求，响应通过echo对应
+type wsTransport struct {
+	c *cqclient
+}
+
+func (t wsTransport) available() bool {
+	return t.c.apiConn.IsConnected()
+}
+
+func (t wsTransport) send(payload *CQWSMessage) (chan *CQResponse, error) {
+	msg, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	ch := t.c.enqEcho(payload.Echo)
+	if err := t.c.apiConn.Send(websocket.TextMessage, msg); err != nil {
+		t.c.deqEcho(payload.Echo)
+		return nil, err
+	}
+	return ch, nil
+}
+
+// httpTransport 通过 http api 发送api请求
+// 请求在单独的协程里完成，响应同样通过管道返回
+type httpTransport struct {
+	c *cqclient
+}
+
+func (t httpTransport) available() bool {
+	return t.c.httpAPIURL() != ""
+}
+
+func (t httpTransport) send(payload *CQWSMessage) (chan *CQResponse, error) {
+	if t.c.httpAPIURL() == "" {
+		return nil, errors.New("http api url is not set")
+	}
+	body, err := json.Marshal(payload.Params)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan *CQResponse, 1)
+	go func() {
+		response := &CQResponse{Status: "failed", RetCode: -1}
+		res, err := t.c.httpConn.Post(t.c.getAPIURL(payload.Action), "application/json", bytes.NewReader(body))
+		if err != nil {
+			logger.Errorf("cqclient http api %s error: %v", payload.Action, err)
+		} else {
+			defer res.Body.Close()
+			if err := json.NewDecoder(res.Body).Decode(response); err != nil {
+				logger.Errorf("cqclient http api %s error: %v", payload.Action, err)
+			} else if response.Failed() {
+				logger.Errorf("cqclient http api %s failed, status = %s, retcode = %d", payload.Action, response.Status, response.RetCode)
+			}
+		}
+		response.Echo = payload.Echo
+		ch <- response
+	}()
+	return ch, nil
+}
+
+// dryRunTransport 不发送api请求，只记录日志
+// 用来在没有QQ账号的情况下测试插件，总是可以发送并且总是返回成功
+type dryRunTransport struct{}
+
+func (t dryRunTransport) available() bool {
+	return true
+}
+
+func (t dryRunTransport) send(payload *CQWSMessage) (chan *CQResponse, error) {
+	params, err := json.Marshal(payload.Params)
+	if err != nil {
+		return nil, err
+	}
+	logger.Service.Infof("(dry run) %s %s", payload.Action, params)
+	ch := make(chan *CQResponse, 1)
+	ch <- &CQResponse{
+		Status: "ok",
+		// 发送消息的动作返回一个假的 message_id
+		Data: map[string]interface{}{"message_id": float64(0)},
+		Echo: payload.Echo,
+	}
+	return ch, nil
+}
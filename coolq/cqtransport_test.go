@@ -0,0 +1,116 @@
+package coolq
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newHTTPAPIServer 模拟酷q的 http api，把收到的请求路径写入返回的管道
+func newHTTPAPIServer(t *testing.T) (*httptest.Server, chan string) {
+	t.Helper()
+	actions := make(chan string, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		actions <- r.URL.Path
+		w.Write([]byte(`{"status":"ok","retcode":0,"data":{"message_id":1}}`))
+	}))
+	return srv, actions
+}
+
+func expectAction(t *testing.T, actions chan string, want string) {
+	t.Helper()
+	select {
+	case got := <-actions:
+		if got != want {
+			t.Fatalf("got request %s, want %s", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("request %s is not sent", want)
+	}
+}
+
+func TestAPISendJSONOverHTTP(t *testing.T) {
+	srv, actions := newHTTPAPIServer(t)
+	defer srv.Close()
+	c := newTestClient(t, func(c *cqclient) {
+		c.SetAPITransport(APITransportHTTP)
+	})
+	c.SetHTTPURL(srv.URL)
+	if !c.IsAPIOk() {
+		t.Fatal("http api should be available after the url is set")
+	}
+	// websocket api 没有连接，不能通过它发送
+	c.APISendJSON(CQWSMessage{
+		Action: ActionSendGroupMsg,
+		Params: CQTypeSendGroupMsg{GroupID: 1, Message: "hello"},
+	})
+	expectAction(t, actions, "/"+ActionSendGroupMsg)
+}
+
+func TestHTTPQueueFlushedWhenURLSet(t *testing.T) {
+	srv, actions := newHTTPAPIServer(t)
+	defer srv.Close()
+	c := newTestClient(t, func(c *cqclient) {
+		c.SetAPITransport(APITransportHTTP)
+	})
+	if c.IsAPIOk() {
+		t.Fatal("http api should not be available without url")
+	}
+	if err := c.SendGroupMsgErr(1, "queued"); err != nil {
+		t.Fatalf("message should be queued, got %v", err)
+	}
+	c.SetHTTPURL(srv.URL)
+	expectAction(t, actions, "/"+ActionSendGroupMsg)
+}
+
+// newWSServer 模拟酷q的 websocket 服务，记录 /api 和 /event 上收到的连接和消息数
+func newWSServer(t *testing.T) (*httptest.Server, *int32) {
+	t.Helper()
+	writes := new(int32)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+			atomic.AddInt32(writes, 1)
+		}
+	}))
+	return srv, writes
+}
+
+func TestDryRunDoesNotWrite(t *testing.T) {
+	srv, writes := newWSServer(t)
+	defer srv.Close()
+	c := newTestClient(t, func(c *cqclient) {
+		c.SetDryRun(true)
+	})
+	defer c.Close()
+	c.Connect("ws"+strings.TrimPrefix(srv.URL, "http"), "")
+	if !c.IsAPIOk() {
+		t.Fatal("api should be available in dry run mode")
+	}
+	if err := c.SendGroupMsgErr(1, "hello"); err != nil {
+		t.Fatalf("send in dry run mode failed: %v", err)
+	}
+	c.APISendJSON(CQWSMessage{Action: ActionSendPrivateMsg, Params: CQTypeSendPrivateMsg{UserID: 1, Message: "hi"}})
+	time.Sleep(100 * time.Millisecond)
+	if c.apiConn.IsConnected() {
+		t.Error("api connection should not be dialed in dry run mode")
+	}
+	if n := atomic.LoadInt32(writes); n != 0 {
+		t.Errorf("%d messages are written to websocket in dry run mode", n)
+	}
+}
@@ -2,10 +2,14 @@ package coolq
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 // Number number
@@ -24,6 +28,16 @@ type Section struct {
 // Message 酷q消息
 type Message []Section
 
+// MessageSegment 消息段，与 Section 相同
+// 按照 onebot 的叫法命名
+type MessageSegment = Section
+
+// cqCodePattern 匹配一个完整的cq码，如 [CQ:at,qq=123]
+var cqCodePattern = regexp.MustCompile(`\[CQ:([a-zA-Z0-9_.\-]+)((?:,[^,=\[\]]+=[^,\[\]]*)*)\]`)
+
+// unescaper cq码反转义
+var unescaper = strings.NewReplacer("&#91;", "[", "&#93;", "]", "&#44;", ",", "&amp;", "&")
+
 // Escape cq码转义
 // & -> &amp;
 // [ -> &#91;
@@ -41,6 +55,79 @@ func Escape(txt string) string {
 	return txt
 }
 
+// Unescape cq码反转义，Escape 的逆操作
+func Unescape(txt string) string {
+	return unescaper.Replace(txt)
+}
+
+// CQCode 生成一个cq码字符串
+// data 的值会被转义，键按字典序输出以保证结果稳定
+func CQCode(typ string, data map[string]string) string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	buff := new(bytes.Buffer)
+	buff.WriteString("[CQ:")
+	buff.WriteString(typ)
+	for _, key := range keys {
+		buff.WriteString(fmt.Sprintf(",%s=%s", key, Escape(data[key])))
+	}
+	buff.WriteByte(']')
+	return buff.String()
+}
+
+// CQImage 生成图片cq码
+func CQImage(file string) string {
+	return CQCode("image", map[string]string{"file": file})
+}
+
+// CQAt 生成@某人的cq码
+func CQAt(qq int64) string {
+	return CQCode("at", map[string]string{"qq": strconv.FormatInt(qq, 10)})
+}
+
+// CQFace 生成QQ表情cq码
+func CQFace(id int) string {
+	return CQCode("face", map[string]string{"id": strconv.Itoa(id)})
+}
+
+// ParseMessage 把包含cq码的原始消息解析成消息段
+// 例如 [CQ:at,qq=123]hello 会被解析为一个at段和一个text段
+// 不合法的cq码会被当作普通文本处理
+func ParseMessage(raw string) []MessageSegment {
+	segments := make([]MessageSegment, 0)
+	addText := func(text string) {
+		if text == "" {
+			return
+		}
+		text = Unescape(text)
+		// 相邻的文本合并成一段
+		if n := len(segments); n > 0 && segments[n-1].Type == "text" {
+			segments[n-1].Data["text"] += text
+			return
+		}
+		segments = append(segments, NewSection("text", map[string]string{"text": text}))
+	}
+	last := 0
+	for _, loc := range cqCodePattern.FindAllStringSubmatchIndex(raw, -1) {
+		addText(raw[last:loc[0]])
+		data := map[string]string{}
+		if loc[5] > loc[4] {
+			// 跳过开头的逗号
+			for _, field := range strings.Split(raw[loc[4]+1:loc[5]], ",") {
+				pair := strings.SplitN(field, "=", 2)
+				data[pair[0]] = Unescape(pair[1])
+			}
+		}
+		segments = append(segments, NewSection(raw[loc[2]:loc[3]], data))
+		last = loc[1]
+	}
+	addText(raw[last:])
+	return segments
+}
+
 // Marshal 序列化成一个包含cq码的信息
 func Marshal(msg Message) []byte {
 	buff := new(bytes.Buffer)
@@ -168,18 +255,188 @@ type QAnonymous struct {
 	Flag string `json:"flag"`
 }
 
+// 上报类型
+const (
+	// PostTypeMessage 消息事件
+	PostTypeMessage = "message"
+	// PostTypeNotice 通知事件
+	PostTypeNotice = "notice"
+	// PostTypeRequest 请求事件
+	PostTypeRequest = "request"
+	// PostTypeMetaEvent 元事件
+	PostTypeMetaEvent = "meta_event"
+)
+
+// MetaEventTypeHeartbeat 心跳元事件
+const MetaEventTypeHeartbeat = "heartbeat"
+
+// CQSender 消息发送者信息
+// 群消息才有 card, area, level, role, title 字段
+type CQSender struct {
+	UserID   int64  `json:"user_id"`
+	Nickname string `json:"nickname"`
+	Card     string `json:"card"`
+	Sex      string `json:"sex"`
+	Age      int32  `json:"age"`
+	Area     string `json:"area"`
+	Level    string `json:"level"`
+	Role     string `json:"role"`
+	Title    string `json:"title"`
+}
+
+// 群成员的角色
+const (
+	// RoleOwner 群主
+	RoleOwner = "owner"
+	// RoleAdmin 管理员
+	RoleAdmin = "admin"
+	// RoleMember 普通成员
+	RoleMember = "member"
+)
+
+// IsAdmin 发送者是否是群主或者管理员
+func (sender CQSender) IsAdmin() bool {
+	return sender.Role == RoleOwner || sender.Role == RoleAdmin
+}
+
 // CQEvent coolq事件上报格式
+// 包含 onebot v11 中消息、通知、请求、元事件四种上报的字段
 type CQEvent struct {
-	Anonymous   QAnonymous `json:"anonymous"`
-	Font        int64      `json:"font"`
-	GroupID     int64      `json:"group_id"`
-	Message     string     `json:"message"`
-	MessageID   int64      `json:"message_id"`
-	MessageType string     `json:"message_type"`
-	PostType    string     `json:"post_type"`
-	RawMessage  string     `json:"raw_message"`
-	SelfID      int64      `json:"self_id"`
-	SubType     string     `json:"sub_type"`
-	Time        int64      `json:"time"`
-	UserID      int64      `json:"user_id"`
+	Anonymous     QAnonymous `json:"anonymous"`
+	Comment       string     `json:"comment"`
+	Duration      int64      `json:"duration"`
+	Flag          string     `json:"flag"`
+	Font          int64      `json:"font"`
+	GroupID       int64      `json:"group_id"`
+	Message       string     `json:"message"`
+	MessageID     int64      `json:"message_id"`
+	MessageType   string     `json:"message_type"`
+	MetaEventType string     `json:"meta_event_type"`
+	NoticeType    string     `json:"notice_type"`
+	OperatorID    int64      `json:"operator_id"`
+	PostType      string     `json:"post_type"`
+	RawMessage    string     `json:"raw_message"`
+	RequestType   string     `json:"request_type"`
+	SelfID        int64      `json:"self_id"`
+	Sender        CQSender   `json:"sender"`
+	SubType       string     `json:"sub_type"`
+	TargetID      int64      `json:"target_id"`
+	Time          int64      `json:"time"`
+	UserID        int64      `json:"user_id"`
+	// Segments 数组格式上报的原始消息段，字符串格式上报时为空
+	Segments []MessageSegment `json:"-"`
+	// stopped 事件是否已经停止传播
+	stopped int32
+}
+
+// cqEventFields 和 CQEvent 字段相同，用来避免 UnmarshalJSON 递归调用
+type cqEventFields CQEvent
+
+// UnmarshalJSON 解析上报事件
+// message 字段可以是cq码字符串，也可以是消息段数组(go-cqhttp 的 array 格式)
+// 数组格式会被转换成cq码字符串，原始的消息段保存在 Segments 中
+func (event *CQEvent) UnmarshalJSON(data []byte) error {
+	aux := struct {
+		*cqEventFields
+		Message json.RawMessage `json:"message"`
+	}{cqEventFields: (*cqEventFields)(event)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	raw := bytes.TrimSpace(aux.Message)
+	switch {
+	case len(raw) == 0 || bytes.Equal(raw, []byte("null")):
+		event.Message = ""
+	case raw[0] == '[':
+		segments, err := decodeSegments(raw)
+		if err != nil {
+			return err
+		}
+		event.Segments = segments
+		event.Message = SegmentsString(segments)
+	default:
+		return json.Unmarshal(raw, &event.Message)
+	}
+	return nil
+}
+
+// decodeSegments 解析消息段数组
+// 有的实现会把数字类型的参数直接用数字表示，这里统一转换成字符串
+func decodeSegments(raw []byte) ([]MessageSegment, error) {
+	items := make([]struct {
+		Type string                     `json:"type"`
+		Data map[string]json.RawMessage `json:"data"`
+	}, 0)
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, err
+	}
+	segments := make([]MessageSegment, 0, len(items))
+	for _, item := range items {
+		data := make(map[string]string, len(item.Data))
+		for key, val := range item.Data {
+			var str string
+			if err := json.Unmarshal(val, &str); err != nil {
+				str = string(val)
+			}
+			data[key] = str
+		}
+		segments = append(segments, NewSection(item.Type, data))
+	}
+	return segments, nil
+}
+
+// SegmentsString 把消息段转换成包含cq码的消息字符串，ParseMessage 的逆操作
+func SegmentsString(segments []MessageSegment) string {
+	buff := new(strings.Builder)
+	for _, segment := range segments {
+		if segment.Type == "text" {
+			buff.WriteString(Escape(segment.Data["text"]))
+			continue
+		}
+		buff.WriteString(CQCode(segment.Type, segment.Data))
+	}
+	return buff.String()
+}
+
+// IsGroupMessage 是否是群消息
+func (event *CQEvent) IsGroupMessage() bool {
+	return event.PostType == PostTypeMessage && event.MessageType == MessageTypeGroup
+}
+
+// IsPrivateMessage 是否是私聊消息
+func (event *CQEvent) IsPrivateMessage() bool {
+	return event.PostType == PostTypeMessage && event.MessageType == MessageTypePrivate
+}
+
+// IsAtMe 消息中是否@了 selfID
+func (event *CQEvent) IsAtMe(selfID int64) bool {
+	qq := strconv.FormatInt(selfID, 10)
+	for _, segment := range ParseMessage(event.Message) {
+		if segment.Type == "at" && segment.Data["qq"] == qq {
+			return true
+		}
+	}
+	return false
+}
+
+// PlainText 去掉所有cq码之后的纯文本消息
+func (event *CQEvent) PlainText() string {
+	buff := new(strings.Builder)
+	for _, segment := range ParseMessage(event.Message) {
+		if segment.Type == "text" {
+			buff.WriteString(segment.Data["text"])
+		}
+	}
+	return buff.String()
+}
+
+// StopPropagation 停止事件传播
+// 当前插件剩下的handler仍然会执行，但是后面的插件不会再收到这个事件
+func (event *CQEvent) StopPropagation() {
+	atomic.StoreInt32(&event.stopped, 1)
+}
+
+// IsPropagationStopped 事件是否已经停止传播
+func (event *CQEvent) IsPropagationStopped() bool {
+	return atomic.LoadInt32(&event.stopped) == 1
 }
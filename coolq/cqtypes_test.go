@@ -0,0 +1,211 @@
+package coolq
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func textSegment(s string) MessageSegment {
+	return NewSection("text", map[string]string{"text": s})
+}
+
+func TestParseMessage(t *testing.T) {
+	cases := []struct {
+		raw  string
+		want []MessageSegment
+	}{
+		{"", []MessageSegment{}},
+		{"hello", []MessageSegment{textSegment("hello")}},
+		{"[CQ:at,qq=123]hello", []MessageSegment{
+			NewSection("at", map[string]string{"qq": "123"}),
+			textSegment("hello"),
+		}},
+		// 相邻的cq码
+		{"[CQ:face,id=1][CQ:face,id=2]", []MessageSegment{
+			NewSection("face", map[string]string{"id": "1"}),
+			NewSection("face", map[string]string{"id": "2"}),
+		}},
+		// 嵌套的cq码只有里面的一个是合法的
+		{"[CQ:at,qq=[CQ:face,id=1]]", []MessageSegment{
+			textSegment("[CQ:at,qq="),
+			NewSection("face", map[string]string{"id": "1"}),
+			textSegment("]"),
+		}},
+		{"[CQ:shake]", []MessageSegment{NewSection("shake", map[string]string{})}},
+		{"[CQ:image,file=a&#44;b&#91;1&#93;.png,url=]", []MessageSegment{
+			NewSection("image", map[string]string{"file": "a,b[1].png", "url": ""}),
+		}},
+		{"a&amp;#91;b &#91;c&#93;", []MessageSegment{textSegment("a&#91;b [c]")}},
+		// 不合法的cq码当作文本
+		{"[CQ:at,qq=123", []MessageSegment{textSegment("[CQ:at,qq=123")}},
+		{"[CQ:at,qq]x", []MessageSegment{textSegment("[CQ:at,qq]x")}},
+		{"[CQ:]", []MessageSegment{textSegment("[CQ:]")}},
+		{"[cq:at,qq=1]", []MessageSegment{textSegment("[cq:at,qq=1]")}},
+		{"a[CQ:at qq=1]b", []MessageSegment{textSegment("a[CQ:at qq=1]b")}},
+	}
+	for _, c := range cases {
+		if got := ParseMessage(c.raw); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParseMessage(%q) = %+v, want %+v", c.raw, got, c.want)
+		}
+	}
+}
+
+func TestParseMessageRoundTrip(t *testing.T) {
+	raws := []string{
+		"[CQ:at,qq=123]hello",
+		"[CQ:face,id=1][CQ:face,id=2]",
+		"a&#44;b&#91;c&#93;&amp;[CQ:image,file=x&#44;y.png]d",
+		"[CQ:at,qq=[CQ:face,id=1]]",
+		"[CQ:share,content=,title=a&#91;b&#93;,url=http://a.com/?x=1&amp;y=2]",
+	}
+	for _, raw := range raws {
+		segments := ParseMessage(raw)
+		again := ParseMessage(SegmentsString(segments))
+		if !reflect.DeepEqual(again, segments) {
+			t.Errorf("round trip of %q = %+v, want %+v", raw, again, segments)
+		}
+	}
+	// 已经是规范形式的消息原样返回
+	for _, raw := range raws[:3] {
+		if got := SegmentsString(ParseMessage(raw)); got != raw {
+			t.Errorf("SegmentsString(ParseMessage(%q)) = %q", raw, got)
+		}
+	}
+}
+
+func TestCQCode(t *testing.T) {
+	cases := []struct {
+		got, want string
+	}{
+		{CQAt(123), "[CQ:at,qq=123]"},
+		{CQFace(14), "[CQ:face,id=14]"},
+		{CQImage("a,b[1]&.png"), "[CQ:image,file=a&#44;b&#91;1&#93;&amp;.png]"},
+		{CQCode("shake", nil), "[CQ:shake]"},
+		// 键按字典序输出
+		{CQCode("share", map[string]string{"url": "u", "title": "t", "content": "c"}), "[CQ:share,content=c,title=t,url=u]"},
+	}
+	for _, c := range cases {
+		if c.got != c.want {
+			t.Errorf("got %q, want %q", c.got, c.want)
+		}
+	}
+}
+
+func TestCQCodeRoundTrip(t *testing.T) {
+	data := map[string]string{
+		"file":  "[CQ:image,file=x.png]",
+		"title": "a,b&#44;c",
+		"empty": "",
+	}
+	raw := "hi " + CQCode("custom", data) + CQAt(1) + CQFace(2) + CQImage("x,y.png")
+	want := []MessageSegment{
+		textSegment("hi "),
+		NewSection("custom", data),
+		NewSection("at", map[string]string{"qq": "1"}),
+		NewSection("face", map[string]string{"id": "2"}),
+		NewSection("image", map[string]string{"file": "x,y.png"}),
+	}
+	if got := ParseMessage(raw); !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseMessage(%q) = %+v, want %+v", raw, got, want)
+	}
+}
+
+// go-cqhttp 上报的群消息，message 为cq码字符串
+const stringEventSample = `{"post_type":"message","message_type":"group","time":1609459200,"self_id":10000,"sub_type":"normal","message_id":-2147480000,"group_id":123456,"user_id":654321,"anonymous":null,"message":"[CQ:at,qq=10000] hello&#44; [CQ:face,id=178]","raw_message":"[CQ:at,qq=10000] hello&#44; [CQ:face,id=178]","font":0,"sender":{"age":0,"area":"","card":"","level":"","nickname":"user","role":"admin","sex":"unknown","title":"","user_id":654321}}`
+
+// 同一条消息使用 array 格式上报，有的字段是数字
+const arrayEventSample = `{"post_type":"message","message_type":"group","time":1609459200,"self_id":10000,"sub_type":"normal","message_id":-2147480000,"group_id":123456,"user_id":654321,"anonymous":null,"message":[{"type":"at","data":{"qq":10000}},{"type":"text","data":{"text":" hello, "}},{"type":"face","data":{"id":"178"}}],"raw_message":"[CQ:at,qq=10000] hello&#44; [CQ:face,id=178]","font":0,"sender":{"age":0,"area":"","card":"","level":"","nickname":"user","role":"admin","sex":"unknown","title":"","user_id":654321}}`
+
+func TestUnmarshalEvent(t *testing.T) {
+	wantSegments := []MessageSegment{
+		NewSection("at", map[string]string{"qq": "10000"}),
+		textSegment(" hello, "),
+		NewSection("face", map[string]string{"id": "178"}),
+	}
+	for name, sample := range map[string]string{"string": stringEventSample, "array": arrayEventSample} {
+		event := new(CQEvent)
+		if err := json.Unmarshal([]byte(sample), event); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if event.Message != "[CQ:at,qq=10000] hello&#44; [CQ:face,id=178]" {
+			t.Errorf("%s: message = %q", name, event.Message)
+		}
+		if event.MessageID != -2147480000 || event.GroupID != 123456 || event.UserID != 654321 || !event.Sender.IsAdmin() {
+			t.Errorf("%s: fields are not decoded: %+v", name, event)
+		}
+		if !reflect.DeepEqual(ParseMessage(event.Message), wantSegments) {
+			t.Errorf("%s: parsed message = %+v", name, ParseMessage(event.Message))
+		}
+		if name == "array" && !reflect.DeepEqual(event.Segments, wantSegments) {
+			t.Errorf("array: segments = %+v", event.Segments)
+		}
+		if name == "string" && event.Segments != nil {
+			t.Errorf("string: segments should be empty, got %+v", event.Segments)
+		}
+	}
+}
+
+func TestUnmarshalEventWithoutMessage(t *testing.T) {
+	samples := []string{
+		`{"post_type":"notice","notice_type":"group_recall","time":1,"self_id":1,"group_id":2,"user_id":3,"operator_id":3,"message_id":4}`,
+		`{"post_type":"message","message":null}`,
+		`{"post_type":"message","message":[]}`,
+	}
+	for _, sample := range samples {
+		event := new(CQEvent)
+		if err := json.Unmarshal([]byte(sample), event); err != nil {
+			t.Errorf("%s: %v", sample, err)
+			continue
+		}
+		if event.Message != "" {
+			t.Errorf("%s: message = %q, want empty", sample, event.Message)
+		}
+	}
+	for _, sample := range []string{`{"message":123}`, `{"message":[1]}`, `{"message":{}}`} {
+		if err := json.Unmarshal([]byte(sample), new(CQEvent)); err == nil {
+			t.Errorf("%s: expected an error", sample)
+		}
+	}
+}
+
+// go-cqhttp 上报的私聊消息，包含图片和转义的方括号
+const stringPrivateSample = `{"post_type":"message","message_type":"private","time":1609459200,"self_id":10000,"sub_type":"friend","message_id":12345,"user_id":654321,"message":"看看[CQ:image,file=abc.image,url=https://gchat.qpic.cn/x?a=1&amp;b=2] &#91;doge&#93;","raw_message":"看看[CQ:image,file=abc.image,url=https://gchat.qpic.cn/x?a=1&amp;b=2] &#91;doge&#93;","font":0,"sender":{"age":0,"nickname":"user","sex":"unknown","user_id":654321}}`
+
+const arrayPrivateSample = `{"post_type":"message","message_type":"private","time":1609459200,"self_id":10000,"sub_type":"friend","message_id":12345,"user_id":654321,"message":[{"type":"text","data":{"text":"看看"}},{"type":"image","data":{"file":"abc.image","url":"https://gchat.qpic.cn/x?a=1&b=2"}},{"type":"text","data":{"text":" [doge]"}}],"raw_message":"看看[CQ:image,file=abc.image,url=https://gchat.qpic.cn/x?a=1&amp;b=2] &#91;doge&#93;","font":0,"sender":{"age":0,"nickname":"user","sex":"unknown","user_id":654321}}`
+
+// @全体成员的群消息
+const stringAtAllSample = `{"post_type":"message","message_type":"group","time":1609459200,"self_id":10000,"sub_type":"normal","message_id":12346,"group_id":123456,"user_id":654321,"anonymous":null,"message":"[CQ:at,qq=all] 开会","raw_message":"[CQ:at,qq=all] 开会","font":0,"sender":{"nickname":"user","role":"owner","user_id":654321}}`
+
+const arrayAtAllSample = `{"post_type":"message","message_type":"group","time":1609459200,"self_id":10000,"sub_type":"normal","message_id":12346,"group_id":123456,"user_id":654321,"anonymous":null,"message":[{"type":"at","data":{"qq":"all"}},{"type":"text","data":{"text":" 开会"}}],"raw_message":"[CQ:at,qq=all] 开会","font":0,"sender":{"nickname":"user","role":"owner","user_id":654321}}`
+
+func TestIsAtMeAndPlainText(t *testing.T) {
+	cases := []struct {
+		name   string
+		sample string
+		atMe   bool
+		text   string
+	}{
+		{"group string", stringEventSample, true, " hello, "},
+		{"group array", arrayEventSample, true, " hello, "},
+		{"private string", stringPrivateSample, false, "看看 [doge]"},
+		{"private array", arrayPrivateSample, false, "看看 [doge]"},
+		// @全体成员不算@了机器人
+		{"at all string", stringAtAllSample, false, " 开会"},
+		{"at all array", arrayAtAllSample, false, " 开会"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			event := decodeEvent(t, tc.sample)
+			if got := event.IsAtMe(event.SelfID); got != tc.atMe {
+				t.Errorf("IsAtMe(%d) = %v, want %v", event.SelfID, got, tc.atMe)
+			}
+			if event.IsAtMe(event.UserID) {
+				t.Errorf("IsAtMe(%d) should be false for the sender", event.UserID)
+			}
+			if got := event.PlainText(); got != tc.text {
+				t.Errorf("PlainText() = %q, want %q", got, tc.text)
+			}
+		})
+	}
+}
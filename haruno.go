@@ -2,12 +2,18 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/BurntSushi/toml"
@@ -19,34 +25,305 @@ import (
 )
 
 type config struct {
-	Version    string `toml:"version"`
-	LogsPath   string `toml:"logsPath"`
-	ServerPort int    `toml:"serverPort"`
-	CQWSURL    string `toml:"cqWSURL"`
-	CQHTTPURL  string `toml:"cqHTTPURL"`
-	CQToken    string `toml:"cqToken"`
-	WebRoot    string `toml:"webroot"`
+	Version          string   `toml:"version"`
+	LogsPath         string   `toml:"logsPath"`
+	LogReplaySize    int      `toml:"logReplaySize"`
+	RetentionDays    int      `toml:"retentionDays"`
+	CompressLogs     bool     `toml:"compressLogs"`
+	MaxLogSizeMB     int      `toml:"maxLogSizeMB"`
+	PersistCounters  bool     `toml:"persistCounters"`
+	LogFormat        string   `toml:"logFormat"`
+	LogLevel         string   `toml:"logLevel"`
+	DropBelowLevel   bool     `toml:"dropBelowLevel"`
+	MaskIPs          *bool    `toml:"maskIPs"`
+	AlertWebhook     string   `toml:"alertWebhook"`
+	AlertErrors      int      `toml:"alertErrors"`
+	AlertWindow      int      `toml:"alertWindow"`
+	AlertCooldown    int      `toml:"alertCooldown"`
+	ServerHost       string   `toml:"serverHost"`
+	ServerPort       int      `toml:"serverPort"`
+	CQMode           string   `toml:"cqMode"`
+	CQWSURL          string   `toml:"cqWSURL"`
+	CQWSURLs         []string `toml:"cqWSURLs"`
+	FailoverRetries  int      `toml:"failoverRetries"`
+	CQHTTPURL        string   `toml:"cqHTTPURL"`
+	CQAPITransport   string   `toml:"cqAPITransport"`
+	CQToken          string   `toml:"cqToken"`
+	DryRun           bool     `toml:"dryRun"`
+	WebRoot          string   `toml:"webroot"`
+	TLSCertFile      string   `toml:"tlsCertFile"`
+	TLSKeyFile       string   `toml:"tlsKeyFile"`
+	DashboardToken   string   `toml:"dashboardToken"`
+	Workers          int      `toml:"workers"`
+	SendRateLimit    float64  `toml:"sendRateLimit"`
+	SendQueueSize    int      `toml:"sendQueueSize"`
+	SendQueueTTL     int      `toml:"sendQueueTTL"`
+	DedupSize        int      `toml:"dedupSize"`
+	ReceiveSelf      bool     `toml:"receiveSelf"`
+	OutboxSize       int      `toml:"outboxSize"`
+	MaxMessageLength int      `toml:"maxMessageLength"`
+	WSWriteTimeout   int      `toml:"wsWriteTimeout"`
+	WSDialTimeout    int      `toml:"wsDialTimeout"`
+	WSSkipVerify     bool     `toml:"wsSkipVerify"`
+	WSProxy          string   `toml:"wsProxy"`
+	StatusInterval   int      `toml:"statusInterval"`
+	ReconnectOffline bool     `toml:"reconnectOffline"`
+	EchoTimeout      int      `toml:"echoTimeout"`
+	CleanupInterval  int      `toml:"cleanupInterval"`
+	HandlerTimeout   int      `toml:"handlerTimeout"`
+	// Plugins 各个插件自己的配置，由插件自己解析
+	Plugins map[string]toml.Primitive `toml:"plugins"`
 }
 
 // haruno 晴乃机器人
 // 机器人运行的全局属性
 type haruno struct {
-	s int64
-	c *config
+	s    int64
+	c    *config
+	md   toml.MetaData
+	path string
 }
 
 const waitTime = time.Second * 15
 
+// defaultConfigPath 默认的配置文件路径
+const defaultConfigPath = "config.toml"
+
+// 连接酷q的方式
+const (
+	// cqModeForward 由机器人主动连接酷q的websocket服务
+	cqModeForward = "forward"
+	// cqModeReverse 由酷q连接机器人的 /cqhttp 接口
+	cqModeReverse = "reverse"
+)
+
+// defaultServerHost 默认只监听本机
+const defaultServerHost = "127.0.0.1"
+
 var bot = new(haruno)
 
-func (bot *haruno) loadConfig() {
+// readConfig 读取并解析配置文件
+func readConfig(path string) (*config, toml.MetaData, error) {
 	cfg := new(config)
-	_, err := toml.DecodeFile("config.toml", cfg)
+	if _, err := os.Stat(path); err != nil {
+		return nil, toml.MetaData{}, fmt.Errorf("config file %s is not found: %v", path, err)
+	}
+	md, err := toml.DecodeFile(path, cfg)
+	if err != nil {
+		return nil, md, err
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, md, err
+	}
+	if cfg.ServerHost == "" {
+		cfg.ServerHost = defaultServerHost
+	}
+	if cfg.CQMode == "" {
+		cfg.CQMode = cqModeForward
+	}
+	if cfg.CQAPITransport == "" {
+		cfg.CQAPITransport = coolq.APITransportWebsocket
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, md, err
+	}
+	return cfg, md, nil
+}
+
+// applyEnv 用环境变量覆盖配置文件中的值
+// 只有环境变量不为空时才会覆盖
+func (cfg *config) applyEnv() error {
+	strs := map[string]*string{
+		"HARUNO_SERVER_HOST":     &cfg.ServerHost,
+		"HARUNO_CQ_WS_URL":       &cfg.CQWSURL,
+		"HARUNO_CQ_HTTP_URL":     &cfg.CQHTTPURL,
+		"HARUNO_CQ_TOKEN":        &cfg.CQToken,
+		"HARUNO_DASHBOARD_TOKEN": &cfg.DashboardToken,
+	}
+	for key, field := range strs {
+		if val := os.Getenv(key); val != "" {
+			*field = val
+		}
+	}
+	if val := os.Getenv("HARUNO_SERVER_PORT"); val != "" {
+		port, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("invalid HARUNO_SERVER_PORT %s: %v", val, err)
+		}
+		cfg.ServerPort = port
+	}
+	return nil
+}
+
+// validate 检查配置是否完整有效
+// 所有的问题会被合并成一个错误返回
+func (cfg *config) validate() error {
+	problems := make([]string, 0)
+	if cfg.ServerPort < 1 || cfg.ServerPort > 65535 {
+		problems = append(problems, fmt.Sprintf("serverPort should be in 1-65535, got %d", cfg.ServerPort))
+	}
+	switch cfg.CQMode {
+	case cqModeForward:
+		if cfg.CQWSURL == "" {
+			problems = append(problems, "cqWSURL is required in forward mode")
+		}
+	case cqModeReverse:
+	default:
+		problems = append(problems, fmt.Sprintf("cqMode should be forward or reverse, got %s", cfg.CQMode))
+	}
+	switch cfg.CQAPITransport {
+	case coolq.APITransportWebsocket:
+	case coolq.APITransportHTTP:
+		if cfg.CQHTTPURL == "" {
+			problems = append(problems, "cqHTTPURL is required when cqAPITransport is http")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("cqAPITransport should be websocket or http, got %s", cfg.CQAPITransport))
+	}
+	if cfg.WebRoot != "" {
+		if _, err := os.Stat(cfg.WebRoot); err != nil {
+			problems = append(problems, fmt.Sprintf("webroot %s is not found", cfg.WebRoot))
+		}
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		problems = append(problems, "tlsCertFile and tlsKeyFile should be set together")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (bot *haruno) loadConfig() {
+	cfg, md, err := readConfig(bot.path)
 	if err != nil {
 		logger.Logger.Fatalln("Haruno Initialize fialed:", err)
 	}
 	bot.s = time.Now().UnixNano() / 1e6
 	bot.c = cfg
+	bot.md = md
+}
+
+// applyConfig 应用可以在运行时修改的配置
+func (bot *haruno) applyConfig() {
+	logger.Service.SetRetentionDays(bot.c.RetentionDays)
+	logger.Service.SetCompressLogs(bot.c.CompressLogs)
+	logger.Service.SetMaxLogSize(bot.c.MaxLogSizeMB)
+	logger.Service.SetLogLevel(bot.c.LogLevel)
+	logger.Service.SetDropBelowLevel(bot.c.DropBelowLevel)
+	// 没有配置时默认屏蔽ip
+	logger.Service.SetMaskIPs(bot.c.MaskIPs == nil || *bot.c.MaskIPs)
+	logger.Service.SetErrorAlert(bot.c.AlertWebhook, bot.c.AlertErrors,
+		time.Duration(bot.c.AlertWindow)*time.Second, time.Duration(bot.c.AlertCooldown)*time.Second)
+	coolq.Client.SetSendRateLimit(bot.c.SendRateLimit)
+	coolq.Client.SetSendQueueSize(bot.c.SendQueueSize)
+	coolq.Client.SetSendQueueTTL(time.Duration(bot.c.SendQueueTTL) * time.Second)
+	coolq.Client.SetDedupSize(bot.c.DedupSize)
+	coolq.Client.SetReceiveSelf(bot.c.ReceiveSelf)
+	coolq.Client.SetOutboxSize(bot.c.OutboxSize)
+	coolq.Client.SetMaxMessageLength(bot.c.MaxMessageLength)
+}
+
+// reloadConfig 重新读取配置文件并应用可以在运行时修改的部分
+// 其余修改过的配置项会被忽略，需要重启才能生效
+func (bot *haruno) reloadConfig() {
+	cfg, _, err := readConfig(bot.path)
+	if err != nil {
+		logger.Logger.Errorln("failed to reload config:", err)
+		return
+	}
+	ignored := make([]string, 0)
+	if cfg.LogsPath != bot.c.LogsPath {
+		ignored = append(ignored, "logsPath")
+	}
+	if cfg.LogReplaySize != bot.c.LogReplaySize {
+		ignored = append(ignored, "logReplaySize")
+	}
+	if cfg.LogFormat != bot.c.LogFormat {
+		ignored = append(ignored, "logFormat")
+	}
+	if cfg.PersistCounters != bot.c.PersistCounters {
+		ignored = append(ignored, "persistCounters")
+	}
+	if cfg.ServerHost != bot.c.ServerHost {
+		ignored = append(ignored, "serverHost")
+	}
+	if cfg.ServerPort != bot.c.ServerPort {
+		ignored = append(ignored, "serverPort")
+	}
+	if cfg.CQMode != bot.c.CQMode {
+		ignored = append(ignored, "cqMode")
+	}
+	if cfg.CQWSURL != bot.c.CQWSURL {
+		ignored = append(ignored, "cqWSURL")
+	}
+	if strings.Join(cfg.CQWSURLs, ",") != strings.Join(bot.c.CQWSURLs, ",") || cfg.FailoverRetries != bot.c.FailoverRetries {
+		ignored = append(ignored, "cqWSURLs/failoverRetries")
+	}
+	if cfg.CQHTTPURL != bot.c.CQHTTPURL {
+		ignored = append(ignored, "cqHTTPURL")
+	}
+	if cfg.CQAPITransport != bot.c.CQAPITransport {
+		ignored = append(ignored, "cqAPITransport")
+	}
+	if cfg.CQToken != bot.c.CQToken {
+		ignored = append(ignored, "cqToken")
+	}
+	if cfg.DryRun != bot.c.DryRun {
+		ignored = append(ignored, "dryRun")
+	}
+	if cfg.WebRoot != bot.c.WebRoot {
+		ignored = append(ignored, "webroot")
+	}
+	if cfg.TLSCertFile != bot.c.TLSCertFile || cfg.TLSKeyFile != bot.c.TLSKeyFile {
+		ignored = append(ignored, "tlsCertFile/tlsKeyFile")
+	}
+	if cfg.DashboardToken != bot.c.DashboardToken {
+		ignored = append(ignored, "dashboardToken")
+	}
+	if cfg.StatusInterval != bot.c.StatusInterval || cfg.ReconnectOffline != bot.c.ReconnectOffline {
+		ignored = append(ignored, "statusInterval/reconnectOffline")
+	}
+	if cfg.EchoTimeout != bot.c.EchoTimeout || cfg.CleanupInterval != bot.c.CleanupInterval {
+		ignored = append(ignored, "echoTimeout/cleanupInterval")
+	}
+	if cfg.WSWriteTimeout != bot.c.WSWriteTimeout {
+		ignored = append(ignored, "wsWriteTimeout")
+	}
+	if cfg.WSDialTimeout != bot.c.WSDialTimeout || cfg.WSSkipVerify != bot.c.WSSkipVerify {
+		ignored = append(ignored, "wsDialTimeout/wsSkipVerify")
+	}
+	if cfg.WSProxy != bot.c.WSProxy {
+		ignored = append(ignored, "wsProxy")
+	}
+	if cfg.Workers != bot.c.Workers {
+		ignored = append(ignored, "workers")
+	}
+	if cfg.HandlerTimeout != bot.c.HandlerTimeout {
+		ignored = append(ignored, "handlerTimeout")
+	}
+	if len(ignored) > 0 {
+		logger.Logger.Warnf("config %s can not be changed without restart, ignored\n", strings.Join(ignored, ", "))
+	}
+	bot.c.RetentionDays = cfg.RetentionDays
+	bot.c.MaxLogSizeMB = cfg.MaxLogSizeMB
+	bot.c.CompressLogs = cfg.CompressLogs
+	bot.c.LogLevel = cfg.LogLevel
+	bot.c.DropBelowLevel = cfg.DropBelowLevel
+	bot.c.MaskIPs = cfg.MaskIPs
+	bot.c.AlertWebhook = cfg.AlertWebhook
+	bot.c.AlertErrors = cfg.AlertErrors
+	bot.c.AlertWindow = cfg.AlertWindow
+	bot.c.AlertCooldown = cfg.AlertCooldown
+	bot.c.SendRateLimit = cfg.SendRateLimit
+	bot.c.SendQueueSize = cfg.SendQueueSize
+	bot.c.SendQueueTTL = cfg.SendQueueTTL
+	bot.c.DedupSize = cfg.DedupSize
+	bot.c.ReceiveSelf = cfg.ReceiveSelf
+	bot.c.OutboxSize = cfg.OutboxSize
+	bot.c.MaxMessageLength = cfg.MaxMessageLength
+	bot.applyConfig()
+	logger.Logger.Println("config has been reloaded")
 }
 
 // Initialize 从配置文件读取配置初始化
@@ -57,20 +334,51 @@ func (bot *haruno) Initialize() {
 	os.Setenv("CQWSURL", bot.c.CQWSURL)
 	os.Setenv("CQTOKEN", bot.c.CQToken)
 	logger.Service.SetLogsPath(bot.c.LogsPath)
+	logger.Service.SetReplayBufferSize(bot.c.LogReplaySize)
+	logger.Service.SetPersistCounters(bot.c.PersistCounters)
+	logger.Service.SetLogFormat(bot.c.LogFormat)
+	bot.applyConfig()
 	logger.Service.Initialize()
 	plugins.SetupPlugins()
+	coolq.Client.SetWorkerPoolSize(bot.c.Workers)
+	coolq.Client.SetPluginConfigs(bot.md, bot.c.Plugins)
+	coolq.Client.SetDryRun(bot.c.DryRun)
+	coolq.Client.SetAPITransport(bot.c.CQAPITransport)
+	coolq.Client.SetWriteTimeout(time.Duration(bot.c.WSWriteTimeout) * time.Second)
+	coolq.Client.SetHandshakeTimeout(time.Duration(bot.c.WSDialTimeout) * time.Second)
+	coolq.Client.SetInsecureSkipVerify(bot.c.WSSkipVerify)
+	coolq.Client.SetProxy(bot.c.WSProxy)
+	coolq.Client.SetStatusPollInterval(time.Duration(bot.c.StatusInterval) * time.Second)
+	coolq.Client.SetReconnectOnOffline(bot.c.ReconnectOffline)
+	coolq.Client.SetEchoTimeout(time.Duration(bot.c.EchoTimeout) * time.Second)
+	coolq.Client.SetCleanupInterval(time.Duration(bot.c.CleanupInterval) * time.Second)
+	coolq.Client.SetHandlerTimeout(time.Duration(bot.c.HandlerTimeout) * time.Second)
 	coolq.Client.Initialize(bot.c.CQToken)
-	go coolq.Client.Connect(bot.c.CQWSURL, bot.c.CQHTTPURL)
+	if bot.c.CQMode == cqModeReverse {
+		// 等待酷q连接 /cqhttp 接口
+		coolq.Client.SetHTTPURL(bot.c.CQHTTPURL)
+	} else {
+		coolq.Client.SetFallbackURLs(bot.c.CQWSURLs)
+		coolq.Client.SetFailoverRetries(bot.c.FailoverRetries)
+		go coolq.Client.Connect(bot.c.CQWSURL, bot.c.CQHTTPURL)
+	}
 	go coolq.Client.RegisterAllPlugins()
 }
 
 // Status 运行状态json格式
 type Status struct {
-	Go      int    `json:"go"`
-	Version string `json:"version"`
-	Success int    `json:"success"`
-	Fails   int    `json:"fails"`
-	Start   int64  `json:"start"`
+	Go             int    `json:"go"`
+	Version        string `json:"version"`
+	Success        int    `json:"success"`
+	Fails          int    `json:"fails"`
+	Start          int64  `json:"start"`
+	FirstStart     int64  `json:"firstStart,omitempty"`
+	APIConnected   bool   `json:"apiConnected"`
+	EventConnected bool   `json:"eventConnected"`
+	Upstream       string `json:"upstream,omitempty"`
+	UptimeSeconds  int64  `json:"uptimeSeconds"`
+	MemAllocBytes  uint64 `json:"memAllocBytes"`
+	NumGC          uint32 `json:"numGC"`
 }
 
 func statusHandler(w http.ResponseWriter, r *http.Request) {
@@ -78,14 +386,123 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 	status.Fails = logger.Service.FailCnt()
 	status.Success = logger.Service.SuccessCnt()
 	status.Start = bot.s
+	status.FirstStart = logger.Service.FirstStart()
 	status.Version = bot.c.Version
+	status.APIConnected = coolq.Client.IsAPIOk()
+	status.EventConnected = coolq.Client.IsEventOk()
+	status.Upstream = coolq.Client.Upstream()
+	status.UptimeSeconds = (time.Now().UnixNano()/1e6 - bot.s) / 1e3
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	status.MemAllocBytes = mem.Alloc
+	status.NumGC = mem.NumGC
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	status.Go = runtime.NumGoroutine()
 	json.NewEncoder(w).Encode(status)
 }
 
+// healthzHandler 存活检查，http服务正常运行时总是返回 ok
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, "ok")
+}
+
+// readyzHandler 就绪检查，event服务连接正常时返回 ok，否则返回 503
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !coolq.Client.IsEventOk() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, "event connection is not ready")
+		return
+	}
+	io.WriteString(w, "ok")
+}
+
+// outboxHandler 获取最近发送的消息
+func outboxHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(coolq.Client.Outbox())
+}
+
+func pluginsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(coolq.Client.PluginsStatus())
+}
+
+// pluginSwitchHandler 启用或禁用插件
+func pluginSwitchHandler(enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		if !coolq.Client.SetPluginEnabled(name, enabled) {
+			http.Error(w, "插件不存在", http.StatusNotFound)
+			return
+		}
+		logger.Infof("plugin %s enabled = %v", name, enabled)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// auth 校验dashboard token
+// 支持 Authorization: Bearer <token> 请求头，或者 ?token= 参数(用于websocket)
+// 没有设置token时不做校验
+func (bot *haruno) auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := bot.c.DashboardToken
+		if token == "" {
+			next(w, r)
+			return
+		}
+		got := r.URL.Query().Get("token")
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			got = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// accessLogSkipped 不记录访问日志的接口
+// 日志流接口每条日志都会触发推送，健康检查会被频繁调用，记录它们只会产生噪音
+var accessLogSkipped = map[string]bool{
+	"/logs/-/type=websocket": true,
+	"/logs/-/type=sse":       true,
+	"/cqhttp":                true,
+	"/healthz":               true,
+	"/readyz":                true,
+}
+
+// statusRecorder 记录响应的状态码
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// accessLog 记录http服务的访问日志
+// 只记录路径，不记录可能带有token的查询参数
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if accessLogSkipped[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		logger.Service.Infof("http: %s %s %d %v from %s", r.Method, r.URL.Path, rec.status, time.Since(start), r.RemoteAddr)
+	})
+}
+
 // Run 启动机器人
-func (bot *haruno) Run() {
+// ctx 被取消或者http服务出错时关闭机器人并返回
+func (bot *haruno) Run(ctx context.Context) error {
 	r := mux.NewRouter()
 
 	if bot.c.WebRoot != "" {
@@ -97,43 +514,104 @@ func (bot *haruno) Run() {
 		}
 	}
 
-	r.Methods(http.MethodGet).Path("/status").HandlerFunc(statusHandler)
-	r.Methods(http.MethodGet).Path("/logs/-/type=websocket").HandlerFunc(logger.WSLogHandler)
-	r.Methods(http.MethodGet).Path("/logs/-/type=plain").HandlerFunc(logger.RawLogHandler)
+	// 给容器编排系统使用的健康检查，不需要token
+	r.Methods(http.MethodGet).Path("/healthz").HandlerFunc(healthzHandler)
+	r.Methods(http.MethodGet).Path("/readyz").HandlerFunc(readyzHandler)
+	r.Methods(http.MethodGet).Path("/status").HandlerFunc(bot.auth(statusHandler))
+	r.Methods(http.MethodGet).Path("/plugins").HandlerFunc(bot.auth(pluginsHandler))
+	r.Methods(http.MethodGet).Path("/outbox").HandlerFunc(bot.auth(outboxHandler))
+	r.Methods(http.MethodPost).Path("/plugins/{name}/enable").HandlerFunc(bot.auth(pluginSwitchHandler(true)))
+	r.Methods(http.MethodPost).Path("/plugins/{name}/disable").HandlerFunc(bot.auth(pluginSwitchHandler(false)))
+	r.Methods(http.MethodGet).Path("/logs/-/type=websocket").HandlerFunc(bot.auth(logger.WSLogHandler))
+	r.Methods(http.MethodGet).Path("/logs/-/type=plain").HandlerFunc(bot.auth(logger.RawLogHandler))
+	r.Methods(http.MethodGet).Path("/logs/-/type=sse").HandlerFunc(bot.auth(logger.SSELogHandler))
+	r.Methods(http.MethodGet).Path("/logs/-/type=file").HandlerFunc(bot.auth(logger.RawLogHandler))
+	r.Methods(http.MethodGet).Path("/logs/recent").HandlerFunc(bot.auth(logger.RecentLogHandler))
+	// 反向websocket使用 cqToken 校验，不经过 dashboardToken
+	if bot.c.CQMode == cqModeReverse {
+		r.Methods(http.MethodGet).Path("/cqhttp").HandlerFunc(coolq.Client.ReverseHandler)
+	}
 
 	srv := &http.Server{
-		Addr:         fmt.Sprintf("127.0.0.1:%d", bot.c.ServerPort),
+		Addr:         fmt.Sprintf("%s:%d", bot.c.ServerHost, bot.c.ServerPort),
 		WriteTimeout: time.Second * 15,
 		ReadTimeout:  time.Second * 15,
 		IdleTimeout:  time.Second * 60,
-		Handler:      r,
+		Handler:      accessLog(r),
 	}
 
+	errc := make(chan error, 1)
 	go func() {
-		logger.Logger.Printf("haruno http server is listening on http://localhost:%d\n", bot.c.ServerPort)
-
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-			logger.Logger.Fatalln(err)
+		var err error
+		// 同时设置了证书和私钥时使用https
+		if bot.c.TLSCertFile != "" && bot.c.TLSKeyFile != "" {
+			logger.Logger.Printf("haruno http server is listening on https://%s\n", srv.Addr)
+			err = srv.ListenAndServeTLS(bot.c.TLSCertFile, bot.c.TLSKeyFile)
+		} else {
+			logger.Logger.Printf("haruno http server is listening on http://%s\n", srv.Addr)
+			err = srv.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			errc <- err
 		}
 	}()
 
-	c := make(chan os.Signal, 1)
-
-	signal.Notify(c, os.Interrupt, os.Kill)
-
-	<-c
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errc:
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), waitTime)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), waitTime)
 	defer cancel()
 
-	srv.Shutdown(ctx)
+	srv.Shutdown(shutdownCtx)
 
 	logger.Logger.Println("haruno is shutting down")
 
-	os.Exit(0)
+	// 先等正在处理的事件处理完，插件卸载时可能还需要发送消息，最后再断开连接
+	coolq.Client.Drain(waitTime)
+
+	coolq.Client.UnloadAllPlugins()
+
+	coolq.Client.Close()
+
+	if err := logger.Service.Close(); err != nil {
+		logger.Logger.Errorln("failed to close logger service:", err)
+	}
+
+	return err
+}
+
+// watchSignals 监听系统信号
+// SIGHUP 重新加载配置，其余信号取消 ctx 关闭机器人
+func (bot *haruno) watchSignals(cancel context.CancelFunc) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, os.Kill, syscall.SIGHUP)
+	for sig := range c {
+		if sig == syscall.SIGHUP {
+			bot.reloadConfig()
+			continue
+		}
+		cancel()
+		return
+	}
 }
 
 func main() {
+	// 配置文件路径，命令行参数优先，其次是环境变量 HARUNO_CONFIG
+	path := os.Getenv("HARUNO_CONFIG")
+	if path == "" {
+		path = defaultConfigPath
+	}
+	flag.StringVar(&bot.path, "config", path, "path of the config file")
+	flag.Parse()
 	bot.Initialize()
-	bot.Run()
+	ctx, cancel := context.WithCancel(context.Background())
+	go bot.watchSignals(cancel)
+	if err := bot.Run(ctx); err != nil {
+		logger.Logger.Errorln(err)
+		os.Exit(1)
+	}
+	os.Exit(0)
 }
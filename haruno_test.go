@@ -0,0 +1,191 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// validConfig 一份能通过检查的配置
+func validConfig() *config {
+	return &config{
+		ServerHost:     defaultServerHost,
+		ServerPort:     8080,
+		CQMode:         cqModeForward,
+		CQWSURL:        "ws://127.0.0.1:6700",
+		CQAPITransport: "websocket",
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	cases := []struct {
+		name     string
+		modify   func(cfg *config)
+		problems []string
+	}{
+		{"valid", func(cfg *config) {}, nil},
+		{"port out of range", func(cfg *config) { cfg.ServerPort = 0 }, []string{"serverPort"}},
+		{"port too large", func(cfg *config) { cfg.ServerPort = 70000 }, []string{"serverPort"}},
+		{"missing ws url", func(cfg *config) { cfg.CQWSURL = "" }, []string{"cqWSURL"}},
+		{"reverse without ws url", func(cfg *config) {
+			cfg.CQMode = cqModeReverse
+			cfg.CQWSURL = ""
+		}, nil},
+		{"unknown mode", func(cfg *config) { cfg.CQMode = "sideways" }, []string{"cqMode"}},
+		{"http transport without url", func(cfg *config) { cfg.CQAPITransport = "http" }, []string{"cqHTTPURL"}},
+		{"unknown transport", func(cfg *config) { cfg.CQAPITransport = "pigeon" }, []string{"cqAPITransport"}},
+		{"missing webroot", func(cfg *config) { cfg.WebRoot = "no/such/dir" }, []string{"webroot"}},
+		{"tls cert without key", func(cfg *config) { cfg.TLSCertFile = "cert.pem" }, []string{"tlsCertFile"}},
+		{"all problems together", func(cfg *config) {
+			cfg.ServerPort = -1
+			cfg.CQWSURL = ""
+			cfg.TLSKeyFile = "key.pem"
+		}, []string{"serverPort", "cqWSURL", "tlsCertFile"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := validConfig()
+			tc.modify(cfg)
+			err := cfg.validate()
+			if len(tc.problems) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected problems %v, got nil", tc.problems)
+			}
+			for _, problem := range tc.problems {
+				if !strings.Contains(err.Error(), problem) {
+					t.Errorf("error %q should mention %s", err, problem)
+				}
+			}
+		})
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "haruno-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	// 复制的示例配置可以直接使用
+	example, err := ioutil.ReadFile("config.example.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readConfig(write("example.toml", string(example))); err != nil {
+		t.Errorf("config.example.toml should be valid: %v", err)
+	}
+	if _, _, err := readConfig(filepath.Join(dir, "missing.toml")); err == nil {
+		t.Error("missing config file should be an error")
+	}
+	if _, _, err := readConfig(write("broken.toml", "serverPort = ")); err == nil {
+		t.Error("broken toml should be an error")
+	}
+	cfg, _, err := readConfig(write("defaults.toml", "serverPort = 8080\ncqWSURL = \"ws://127.0.0.1:6700\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ServerHost != defaultServerHost || cfg.CQMode != cqModeForward || cfg.CQAPITransport != "websocket" {
+		t.Errorf("defaults are not applied: %+v", cfg)
+	}
+	if _, _, err := readConfig(write("noport.toml", "cqWSURL = \"ws://127.0.0.1:6700\"\n")); err == nil {
+		t.Error("config without serverPort should be invalid")
+	}
+}
+
+// harunoEnvKeys applyEnv 读取的环境变量
+var harunoEnvKeys = []string{
+	"HARUNO_SERVER_HOST",
+	"HARUNO_SERVER_PORT",
+	"HARUNO_CQ_WS_URL",
+	"HARUNO_CQ_HTTP_URL",
+	"HARUNO_CQ_TOKEN",
+	"HARUNO_DASHBOARD_TOKEN",
+}
+
+// setHarunoEnv 只设置 env 中的 HARUNO_* 环境变量，返回的函数恢复原来的值
+func setHarunoEnv(env map[string]string) func() {
+	old := make(map[string]string)
+	for _, key := range harunoEnvKeys {
+		if val, ok := os.LookupEnv(key); ok {
+			old[key] = val
+		}
+		os.Unsetenv(key)
+	}
+	for key, val := range env {
+		os.Setenv(key, val)
+	}
+	return func() {
+		for _, key := range harunoEnvKeys {
+			os.Unsetenv(key)
+		}
+		for key, val := range old {
+			os.Setenv(key, val)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	cases := []struct {
+		name   string
+		env    map[string]string
+		modify func(cfg *config)
+		err    string
+	}{
+		{"no overrides", nil, func(cfg *config) {}, ""},
+		{"server host", map[string]string{"HARUNO_SERVER_HOST": "0.0.0.0"}, func(cfg *config) { cfg.ServerHost = "0.0.0.0" }, ""},
+		{"server port", map[string]string{"HARUNO_SERVER_PORT": "9000"}, func(cfg *config) { cfg.ServerPort = 9000 }, ""},
+		{"ws url", map[string]string{"HARUNO_CQ_WS_URL": "ws://cqhttp:6700"}, func(cfg *config) { cfg.CQWSURL = "ws://cqhttp:6700" }, ""},
+		{"http url", map[string]string{"HARUNO_CQ_HTTP_URL": "http://cqhttp:5700"}, func(cfg *config) { cfg.CQHTTPURL = "http://cqhttp:5700" }, ""},
+		{"tokens", map[string]string{
+			"HARUNO_CQ_TOKEN":        "cq-secret",
+			"HARUNO_DASHBOARD_TOKEN": "dashboard-secret",
+		}, func(cfg *config) {
+			cfg.CQToken = "cq-secret"
+			cfg.DashboardToken = "dashboard-secret"
+		}, ""},
+		// 空的环境变量不覆盖配置文件
+		{"empty values", map[string]string{
+			"HARUNO_SERVER_HOST": "",
+			"HARUNO_SERVER_PORT": "",
+			"HARUNO_CQ_WS_URL":   "",
+		}, func(cfg *config) {}, ""},
+		{"invalid port", map[string]string{"HARUNO_SERVER_PORT": "eighty"}, func(cfg *config) {}, "invalid HARUNO_SERVER_PORT eighty"},
+		{"invalid port with other overrides", map[string]string{
+			"HARUNO_SERVER_PORT": "80a",
+			"HARUNO_CQ_TOKEN":    "cq-secret",
+		}, func(cfg *config) { cfg.CQToken = "cq-secret" }, "invalid HARUNO_SERVER_PORT 80a"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer setHarunoEnv(tc.env)()
+			cfg := validConfig()
+			want := validConfig()
+			tc.modify(want)
+			err := cfg.applyEnv()
+			if tc.err == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Fatalf("expected error %q, got %v", tc.err, err)
+			}
+			if !reflect.DeepEqual(cfg, want) {
+				t.Errorf("got config %+v, want %+v", *cfg, *want)
+			}
+		})
+	}
+}
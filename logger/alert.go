@@ -0,0 +1,117 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 错误告警相关的默认值
+const (
+	defaultAlertWindow   = time.Minute
+	defaultAlertCooldown = 10 * time.Minute
+	// maxAlertTexts 告警中附带的最近错误数
+	maxAlertTexts = 10
+	alertTimeout  = 10 * time.Second
+)
+
+// alertPayload 发送到webhook的告警内容
+// text 字段可以直接被 Slack 等常见的 webhook 使用
+type alertPayload struct {
+	Text   string   `json:"text"`
+	Errors int      `json:"errors"`
+	Window int64    `json:"window"`
+	Recent []string `json:"recent"`
+}
+
+// errorAlert 统计一段时间内的错误日志数，超过阈值时向webhook发送告警
+// 发送告警之后的 cooldown 时间内不会再次发送
+type errorAlert struct {
+	mu        sync.Mutex
+	url       string
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	times     []time.Time
+	texts     []string
+	lastSent  time.Time
+}
+
+// alertClient 发送告警使用的http客户端
+var alertClient = &http.Client{Timeout: alertTimeout}
+
+// SetErrorAlert 设置错误告警
+// window 时间内出现 threshold 条以上的错误日志时向 url 发送告警，之后 cooldown 时间内不会重复发送
+// url 为空或者 threshold <= 0 时不告警，window 和 cooldown <= 0 时使用默认值 1分钟 和 10分钟
+// 可以在运行时调用
+func (logger *loggerService) SetErrorAlert(url string, threshold int, window, cooldown time.Duration) {
+	if window <= 0 {
+		window = defaultAlertWindow
+	}
+	if cooldown <= 0 {
+		cooldown = defaultAlertCooldown
+	}
+	alert := &logger.alert
+	alert.mu.Lock()
+	defer alert.mu.Unlock()
+	alert.url = url
+	alert.threshold = threshold
+	alert.window = window
+	alert.cooldown = cooldown
+}
+
+// observe 记录一条错误日志，达到阈值时在后台发送告警
+func (alert *errorAlert) observe(text string) {
+	alert.mu.Lock()
+	defer alert.mu.Unlock()
+	if alert.url == "" || alert.threshold <= 0 {
+		return
+	}
+	now := time.Now()
+	alert.times = append(alert.times, now)
+	alert.texts = append(alert.texts, text)
+	if len(alert.texts) > maxAlertTexts {
+		alert.texts = alert.texts[len(alert.texts)-maxAlertTexts:]
+	}
+	// 去掉统计窗口之外的错误
+	i := 0
+	for i < len(alert.times) && now.Sub(alert.times[i]) > alert.window {
+		i++
+	}
+	alert.times = alert.times[i:]
+	if len(alert.times) < alert.threshold || now.Sub(alert.lastSent) < alert.cooldown {
+		return
+	}
+	alert.lastSent = now
+	recent := make([]string, len(alert.texts))
+	copy(recent, alert.texts)
+	payload := &alertPayload{
+		Text:   fmt.Sprintf("haruno: %d errors in the last %v", len(alert.times), alert.window),
+		Errors: len(alert.times),
+		Window: int64(alert.window / time.Second),
+		Recent: recent,
+	}
+	go alert.send(alert.url, payload)
+}
+
+// send 发送告警
+// 发送失败只输出到控制台，避免错误日志再次触发告警
+func (alert *errorAlert) send(url string, payload *alertPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		Logger.Errorln("failed to send error alert:", err)
+		return
+	}
+	res, err := alertClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		Logger.Errorln("failed to send error alert:", err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		Logger.Errorf("failed to send error alert: webhook responded %s\n", res.Status)
+	}
+}
@@ -0,0 +1,168 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// clientQueueSize 每个websocket连接的发送队列长度
+const clientQueueSize = 64
+
+// logSubscriber 日志的订阅者，比如websocket连接和sse连接
+type logSubscriber interface {
+	// enqueue 把日志放入发送队列，不能阻塞
+	enqueue(out *preparedLog) bool
+	closed() bool
+	close()
+}
+
+// preparedLog 序列化之后的日志
+// 每条日志只序列化一次，再分发给所有的订阅者
+type preparedLog struct {
+	typ  int
+	data []byte
+	ws   *websocket.PreparedMessage
+}
+
+// logFilter 订阅者想要接收的日志类型，nil 表示接收所有类型
+type logFilter map[int]bool
+
+// logTypeNames 日志类型的名字，用于解析请求参数
+var logTypeNames = map[string]int{
+	"info":    LogTypeInfo,
+	"error":   LogTypeError,
+	"success": LogTypeSuccess,
+}
+
+// parseLogFilter 解析形如 "error" 或者 "error,success" 的日志类型列表
+// 为空时接收所有类型
+func parseLogFilter(query string) (logFilter, error) {
+	if query == "" {
+		return nil, nil
+	}
+	filter := make(logFilter)
+	for _, name := range strings.Split(query, ",") {
+		typ, ok := logTypeNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown log type %s", name)
+		}
+		filter[typ] = true
+	}
+	return filter, nil
+}
+
+// accept 是否接收这个类型的日志
+func (filter logFilter) accept(typ int) bool {
+	return filter == nil || filter[typ]
+}
+
+// logClient 一个日志websocket连接
+// gorilla websocket 不允许并发写，所有的写操作都在 writeLoop 中串行执行
+type logClient struct {
+	types logFilter
+	conn  *websocket.Conn
+	send  chan *websocket.PreparedMessage
+	quit  chan struct{}
+	once  sync.Once
+}
+
+func newLogClient(conn *websocket.Conn) *logClient {
+	return &logClient{
+		conn: conn,
+		send: make(chan *websocket.PreparedMessage, clientQueueSize),
+		quit: make(chan struct{}),
+	}
+}
+
+// prepareLog 把日志序列化成可以发送给多个连接的消息
+func prepareLog(lg *Log) (*preparedLog, error) {
+	data, err := json.Marshal(lg)
+	if err != nil {
+		return nil, err
+	}
+	ws, err := websocket.NewPreparedMessage(websocket.TextMessage, data)
+	if err != nil {
+		return nil, err
+	}
+	return &preparedLog{typ: lg.Type, data: data, ws: ws}, nil
+}
+
+// enqueue 把消息放入发送队列
+// 队列满了说明客户端太慢，直接丢弃而不阻塞广播
+func (client *logClient) enqueue(out *preparedLog) bool {
+	if !client.types.accept(out.typ) {
+		return true
+	}
+	select {
+	case client.send <- out.ws:
+		return true
+	default:
+		return false
+	}
+}
+
+// enqueueLog 序列化日志并放入订阅者的发送队列
+func enqueueLog(sub logSubscriber, lg *Log) bool {
+	out, err := prepareLog(lg)
+	if err != nil {
+		return false
+	}
+	return sub.enqueue(out)
+}
+
+// closed 连接是否已经关闭
+func (client *logClient) closed() bool {
+	select {
+	case <-client.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// close 关闭连接，可以重复调用
+func (client *logClient) close() {
+	client.once.Do(func() {
+		close(client.quit)
+		client.conn.Close()
+	})
+}
+
+// readLoop 读取客户端消息，用于处理控制帧和发现连接断开
+func (client *logClient) readLoop() {
+	defer client.close()
+	for {
+		if _, _, err := client.conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// writeLoop 串行写入日志和心跳，直到连接关闭
+func (client *logClient) writeLoop() {
+	ticker := time.NewTicker(pongWaitTime)
+	pongMsg := []byte("")
+	defer ticker.Stop()
+	defer client.close()
+	for {
+		select {
+		case <-client.quit:
+			return
+		case msg := <-client.send:
+			client.conn.SetWriteDeadline(time.Now().Add(pongWaitTime))
+			if err := client.conn.WritePreparedMessage(msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			client.conn.SetWriteDeadline(time.Now().Add(pongWaitTime))
+			if err := client.conn.WriteMessage(websocket.PongMessage, pongMsg); err != nil {
+				return
+			}
+		}
+	}
+}
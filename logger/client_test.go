@@ -0,0 +1,224 @@
+package logger
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// logReader 一个接收日志的websocket连接，记录收到的日志
+type logReader struct {
+	conn *websocket.Conn
+	mu   sync.Mutex
+	logs []*Log
+	done chan struct{}
+}
+
+// dialLogs 连接日志服务并在后台读取日志，直到连接断开
+func dialLogs(t *testing.T, url string) *logReader {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := &logReader{conn: conn, done: make(chan struct{})}
+	go func() {
+		defer close(reader.done)
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			lg := new(Log)
+			if err := json.Unmarshal(msg, lg); err != nil {
+				t.Errorf("corrupted log %q: %v", msg, err)
+				return
+			}
+			reader.mu.Lock()
+			reader.logs = append(reader.logs, lg)
+			reader.mu.Unlock()
+		}
+	}()
+	return reader
+}
+
+// received 是否收到过内容为 text 的日志
+func (reader *logReader) received(text string) bool {
+	reader.mu.Lock()
+	defer reader.mu.Unlock()
+	for _, lg := range reader.logs {
+		if lg.Text == text {
+			return true
+		}
+	}
+	return false
+}
+
+// closed 等待服务端断开连接
+func (reader *logReader) closed(timeout time.Duration) bool {
+	select {
+	case <-reader.done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// waitUntil 等待 cond 成立，超时返回 false
+func waitUntil(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return true
+}
+
+// serveLogClients 启动一个把 service 的日志推送给websocket连接的服务
+func serveLogClients(service *loggerService) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client := newLogClient(conn)
+		service.addClient(client)
+		defer service.delClient(client)
+		go client.readLoop()
+		client.writeLoop()
+	}))
+}
+
+func TestStreamToConcurrentClients(t *testing.T) {
+	defer discardConsole()()
+	service := newTestService(t, "stream")
+	defer service.Close()
+	srv := serveLogClients(service)
+	defer srv.Close()
+	const clients, writers = 4, 4
+	readers := make([]*logReader, clients)
+	for i := range readers {
+		readers[i] = dialLogs(t, srv.URL)
+	}
+	if !waitUntil(2*time.Second, func() bool { return service.connCount() == clients }) {
+		t.Fatalf("%d of %d clients are registered", service.connCount(), clients)
+	}
+	// 一边写日志，一边有连接断开
+	var wg sync.WaitGroup
+	wg.Add(writers + 1)
+	for i := 0; i < writers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				service.Infof("writer %d log %d", i, j)
+			}
+		}(i)
+	}
+	go func() {
+		defer wg.Done()
+		readers[0].conn.Close()
+	}()
+	wg.Wait()
+	// 队列满的时候日志会被丢弃，重复发送直到每个连接都收到
+	alive := readers[1:]
+	ok := waitUntil(2*time.Second, func() bool {
+		service.Info("last")
+		for _, reader := range alive {
+			if !reader.received("last") {
+				return false
+			}
+		}
+		return true
+	})
+	if !ok {
+		t.Fatal("not every client receives the last log")
+	}
+	service.Close()
+	for i, reader := range alive {
+		if !reader.closed(2 * time.Second) {
+			t.Errorf("client %d is not closed after the service is closed", i+1)
+		}
+	}
+}
+
+var initServiceOnce sync.Once
+
+// initService 初始化全局的日志服务，供直接使用 Service 的处理函数测试
+// 全局服务在测试期间不会关闭
+func initService() {
+	initServiceOnce.Do(func() {
+		Service.SetLogsPath("service")
+		Service.Initialize()
+	})
+}
+
+func TestWSLogHandler(t *testing.T) {
+	defer discardConsole()()
+	initService()
+	srv := httptest.NewServer(http.HandlerFunc(WSLogHandler))
+	defer srv.Close()
+	Service.Info("before connect")
+	readers := []*logReader{dialLogs(t, srv.URL), dialLogs(t, srv.URL)}
+	defer readers[1].conn.Close()
+	if !waitUntil(2*time.Second, func() bool { return Service.connCount() == len(readers) }) {
+		t.Fatalf("%d of %d clients are registered", Service.connCount(), len(readers))
+	}
+	for i, reader := range readers {
+		// 连接之后先收到欢迎消息和最近的日志
+		if !waitUntil(2*time.Second, func() bool { return reader.received("before connect") }) {
+			t.Errorf("client %d does not receive recent logs", i)
+		}
+		if !reader.received("Logger服务连接成功!") {
+			t.Errorf("client %d does not receive the welcome message", i)
+		}
+	}
+	Service.Info("broadcast")
+	for i, reader := range readers {
+		if !waitUntil(2*time.Second, func() bool { return reader.received("broadcast") }) {
+			t.Errorf("client %d does not receive the broadcast log", i)
+		}
+	}
+	// 断开的连接会被移除，剩下的连接不受影响
+	readers[0].conn.Close()
+	if !waitUntil(2*time.Second, func() bool {
+		Service.Info("pruning")
+		return Service.connCount() == 1
+	}) {
+		t.Fatalf("dead client is not removed, %d clients left", Service.connCount())
+	}
+	Service.Info("after pruning")
+	if !waitUntil(2*time.Second, func() bool { return readers[1].received("after pruning") }) {
+		t.Error("remaining client does not receive logs after pruning")
+	}
+}
+
+func TestBroadcastPrunesClosedClients(t *testing.T) {
+	defer discardConsole()()
+	service := newTestService(t, "prune")
+	defer service.Close()
+	live, dead := newSSEClient(), newSSEClient()
+	service.addClient(live)
+	service.addClient(dead)
+	// 没有被处理函数移除的连接在下一次广播时移除
+	dead.close()
+	service.Info("prune")
+	if !waitUntil(2*time.Second, func() bool { return service.connCount() == 1 }) {
+		t.Fatalf("closed client is not pruned, %d clients left", service.connCount())
+	}
+	select {
+	case data := <-live.send:
+		if !strings.Contains(string(data), `"text":"prune"`) {
+			t.Errorf("live client receives %s", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("live client does not receive the log")
+	}
+}
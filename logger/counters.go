@@ -0,0 +1,82 @@
+package logger
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync/atomic"
+	"time"
+)
+
+// countersFile 保存计数器的文件，位于日志目录下
+const countersFile = "counters.json"
+
+// countersSaveInterval 定时保存计数器的间隔
+const countersSaveInterval = 30 * time.Second
+
+// countersState 保存到文件中的计数器
+type countersState struct {
+	Success    int64 `json:"success"`
+	Fails      int64 `json:"fails"`
+	FirstStart int64 `json:"firstStart"`
+}
+
+// SetPersistCounters 设置是否把成功、失败计数保存到日志目录，重启后继续累计
+// 需要在 Initialize 之前调用
+func (logger *loggerService) SetPersistCounters(persist bool) {
+	logger.persist = persist
+}
+
+// FirstStart 第一次启动的时间(毫秒)，没有保存计数器时为0
+func (logger *loggerService) FirstStart() int64 {
+	return logger.firstStart
+}
+
+func (logger *loggerService) countersPath() string {
+	return path.Join(logger.LogsPath(), countersFile)
+}
+
+// loadCounters 读取保存的计数器
+// 文件不存在或者已经损坏时从零开始计数
+func (logger *loggerService) loadCounters() {
+	state := new(countersState)
+	data, err := ioutil.ReadFile(logger.countersPath())
+	if err == nil {
+		err = json.Unmarshal(data, state)
+	}
+	if err != nil {
+		if !os.IsNotExist(err) {
+			Logger.Warnf("failed to load counters, start from zero: %v\n", err)
+		}
+		state = new(countersState)
+	}
+	if state.FirstStart <= 0 {
+		state.FirstStart = time.Now().UnixNano() / 1e6
+	}
+	atomic.AddInt64(&logger.success, state.Success)
+	atomic.AddInt64(&logger.fails, state.Fails)
+	logger.firstStart = state.FirstStart
+}
+
+// saveCounters 保存计数器
+// 先写入临时文件再改名，避免写到一半时退出损坏文件
+func (logger *loggerService) saveCounters() {
+	data, err := json.Marshal(countersState{
+		Success:    atomic.LoadInt64(&logger.success),
+		Fails:      atomic.LoadInt64(&logger.fails),
+		FirstStart: logger.firstStart,
+	})
+	if err != nil {
+		Logger.Errorln("failed to save counters:", err)
+		return
+	}
+	name := logger.countersPath()
+	if err := ioutil.WriteFile(name+".tmp", data, 0600); err != nil {
+		Logger.Errorln("failed to save counters:", err)
+		return
+	}
+	if err := os.Rename(name+".tmp", name); err != nil {
+		Logger.Errorln("failed to save counters:", err)
+	}
+}
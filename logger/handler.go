@@ -1,11 +1,12 @@
 package logger
 
 import (
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"path"
 	"strings"
 	"time"
 
@@ -23,84 +24,112 @@ const (
 var upgrader = websocket.Upgrader{}
 
 // WSLogHandler 广播log
+// 可以用 ?type=error,success 只接收某些类型的日志
 func WSLogHandler(w http.ResponseWriter, r *http.Request) {
+	types, err := parseLogFilter(r.URL.Query().Get("type"))
+	if err != nil {
+		http.Error(w, RequestParamError, http.StatusBadRequest)
+		return
+	}
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		Service.Errorf("Logger WSLogHandler error: %v", err)
 		return
 	}
-	var welMsg = NewLog(LogTypeInfo, "Logger服务连接成功!")
-	Service.setConn(conn, true)
-	quit := make(chan int)
-	setupPong(conn, quit)
-	conn.WriteJSON(welMsg)
-	for {
-		if !Service.conns[conn] {
-			close(quit)
-			return
-		}
-		select {
-		case <-quit:
-			return
-		case lg := <-Service.logChan:
-			for c, ok := range Service.conns {
-				if !ok {
-					continue
-				}
-				err := c.WriteJSON(lg)
-				if err != nil {
-					Service.setConn(c, false)
-				}
-			}
-		}
+	client := newLogClient(conn)
+	enqueueLog(client, NewLog(LogTypeInfo, "Logger服务连接成功!"))
+	client.types = types
+	// 先发送最近的日志
+	for _, lg := range Service.recentLogs() {
+		enqueueLog(client, lg)
+	}
+	Service.addClient(client)
+	defer Service.delClient(client)
+	go client.readLoop()
+	client.writeLoop()
+}
 
+// RecentLogHandler 以json数组的格式返回最近的日志
+// 可以用 ?type=error,success 只获取某些类型的日志
+func RecentLogHandler(w http.ResponseWriter, r *http.Request) {
+	types, err := parseLogFilter(r.URL.Query().Get("type"))
+	if err != nil {
+		http.Error(w, RequestParamError, http.StatusBadRequest)
+		return
 	}
+	logs := make([]*Log, 0)
+	for _, lg := range Service.Recent() {
+		if types.accept(lg.Type) {
+			logs = append(logs, lg)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(logs)
 }
 
-// RawLogHandler 获取log文件
+// RawLogHandler 获取某一天的log文件
+// date 为 YYYY-MM-DD 格式的日期，scope(或者 type) 为空时获取普通日志，为 error 时获取错误日志
+// 已经被压缩的日志会解压之后返回
 func RawLogHandler(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 	date := query.Get("date")
-	logType := query.Get("type")
+	scope := query.Get("scope")
+	if scope == "" {
+		scope = query.Get("type")
+	}
+	// 日期必须能被解析，避免路径穿越
 	tim, err := time.Parse(logDateFormat, date)
 	if date == "" || err != nil {
 		http.Error(w, RequestParamError, 404)
 		return
 	}
-	logfileName := fmt.Sprintf("%s.log", tim.Format(logDateFormat))
-	if len(logType) != 0 {
-		if strings.ToLower(logType) == "error" {
-			logfileName = fmt.Sprintf("%s-%s.log", tim.Format(logDateFormat), logType)
-		} else {
-			http.Error(w, RequestParamError, 404)
-			return
-		}
-	}
-	logfilePath := path.Join(Service.LogsPath(), logfileName)
-	stat, err := os.Stat(logfilePath)
-	if err != nil && os.IsNotExist(err) {
-		http.Error(w, FileNotFoundError, 404)
+	scope = strings.ToLower(scope)
+	if scope != "" && scope != "error" {
+		http.Error(w, RequestParamError, 404)
 		return
 	}
-	if stat.Size() == 0 {
-		http.Error(w, LogFileEmptyMsg, 200)
-		return
+	logfilePath := Service.logFileAt(tim, scope)
+	// 当天的日志可能还在缓冲区里
+	Service.flush()
+	fp, err := os.Open(logfilePath)
+	compressed := false
+	if os.IsNotExist(err) {
+		fp, err = os.Open(logfilePath + ".gz")
+		compressed = true
 	}
-	fp, err := os.OpenFile(logfilePath, os.O_RDONLY, 0600)
 	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, FileNotFoundError, 404)
+			return
+		}
 		Logger.Println(err)
 		http.Error(w, InnerServerError, 500)
 		return
 	}
 	defer fp.Close()
-	w.Header().Add("Content-Type", "text/plain; charset=utf-8")
-	w.Header().Add("Content-Length", fmt.Sprintf("%d", stat.Size()))
-	buff := make([]byte, 100)
-	for {
-		cnt, err := fp.Read(buff)
-		w.Write(buff[:cnt])
-		if err == io.EOF {
+	var reader io.Reader = fp
+	if compressed {
+		zr, err := gzip.NewReader(fp)
+		if err != nil {
+			Logger.Println(err)
+			http.Error(w, InnerServerError, 500)
+			return
+		}
+		defer zr.Close()
+		reader = zr
+	} else {
+		stat, err := fp.Stat()
+		if err != nil {
+			Logger.Println(err)
+			http.Error(w, InnerServerError, 500)
 			return
 		}
+		if stat.Size() == 0 {
+			http.Error(w, LogFileEmptyMsg, 200)
+			return
+		}
+		w.Header().Add("Content-Length", fmt.Sprintf("%d", stat.Size()))
 	}
+	w.Header().Add("Content-Type", "text/plain; charset=utf-8")
+	io.Copy(w, reader)
 }
@@ -1,16 +1,22 @@
 package logger
 
 import (
+	"bufio"
+	"compress/gzip"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"os"
 	"path"
 	"regexp"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
-
-	"github.com/gorilla/websocket"
 )
 
 // Logger 应用使用的 logger 实例
@@ -25,8 +31,9 @@ const LogTypeError = 1
 // LogTypeSuccess 成功类型
 const LogTypeSuccess = 2
 
-// maxQueueSize 队列最大大小
+// maxQueueSize 默认的队列最大大小
 // == 用户首次通过websocket链接能看到的最大的日志数量
+// 可以通过 SetReplayBufferSize 修改
 const maxQueueSize = 10
 
 var logTypeStr = []string{"info", "error", "success"}
@@ -100,18 +107,35 @@ func (logger *loggerWithField) Errorf(format string, args ...interface{}) {
 }
 
 type loggerService struct {
-	conns    map[*websocket.Conn]bool
-	success  int
-	fails    int
+	// 计数器会被多个协程同时访问，使用atomic操作
+	// 放在结构体开头保证32位平台上的64位对齐
+	success  int64
+	fails    int64
+	conns    map[logSubscriber]bool
 	logsPath string
 	logChan  chan *Log
-	logLT    string
-	fpSI     *os.File
-	fpE      *os.File
-	logS     *logrus.Entry
-	logI     *logrus.Entry
-	logE     *logrus.Entry
-	wscLock  sync.Mutex
+	recent   *Ring
+	replay   int
+	format   string
+	// opts 保存 *logOptions，重新加载配置时整个替换
+	opts       atomic.Value
+	optsLock   sync.Mutex
+	persist    bool
+	firstStart int64
+	logLT      string
+	outSI      *logFileWriter
+	outE       *logFileWriter
+	flushQuit  chan struct{}
+	rollLock   sync.Mutex
+	hooks      []logrus.Hook
+	alert      errorAlert
+	logS       *logrus.Entry
+	logI       *logrus.Entry
+	logE       *logrus.Entry
+	wscLock    sync.Mutex
+	// closeLock 保证 Close 之后不会再写入文件和管道
+	closeLock sync.RWMutex
+	isClosed  bool
 	LogInterface
 }
 
@@ -119,14 +143,147 @@ type loggerService struct {
 const logDateFormat = "2006-01-02"
 const pongWaitTime = 5 * time.Second
 
+// 日志文件缓冲区的大小和写入磁盘的间隔
+const (
+	logBufferSize    = 32 * 1024
+	logFlushInterval = time.Second
+)
+
 // Service 单例实体
 var Service loggerService
 
+// logOptions 可以在运行时修改的日志设置
+// 重新加载配置和写日志在不同的协程中，修改时复制一份新的设置替换旧的，读取到的设置不会再被修改
+type logOptions struct {
+	keepDays int
+	compress bool
+	// 零值为屏蔽ip，保持原有的行为
+	showIPs bool
+	// minLevel 写入文件的最低日志级别，见 logLevels
+	minLevel   int
+	dropStream bool
+	maxSize    int64
+}
+
+// options 获取当前的日志设置
+func (logger *loggerService) options() *logOptions {
+	if opts, ok := logger.opts.Load().(*logOptions); ok {
+		return opts
+	}
+	return &logOptions{}
+}
+
+// setOptions 复制当前的日志设置，用 update 修改后替换
+func (logger *loggerService) setOptions(update func(opts *logOptions)) {
+	logger.optsLock.Lock()
+	defer logger.optsLock.Unlock()
+	opts := *logger.options()
+	update(&opts)
+	logger.opts.Store(&opts)
+}
+
 // SetLogsPath 设置log文件目录
 func (logger *loggerService) SetLogsPath(p string) {
 	logger.logsPath = p
 }
 
+// SetReplayBufferSize 设置保存最近日志的数量
+// 需要在 Initialize 之前调用，n <= 0 时使用默认值
+func (logger *loggerService) SetReplayBufferSize(n int) {
+	logger.replay = n
+}
+
+// SetRetentionDays 设置日志文件保留的天数
+// n <= 0 时不清理旧的日志文件
+func (logger *loggerService) SetRetentionDays(n int) {
+	logger.setOptions(func(opts *logOptions) {
+		opts.keepDays = n
+	})
+}
+
+// SetCompressLogs 设置是否在日期切换后压缩前一天的日志文件
+func (logger *loggerService) SetCompressLogs(compress bool) {
+	logger.setOptions(func(opts *logOptions) {
+		opts.compress = compress
+	})
+}
+
+// SetLogFormat 设置日志文件的格式
+// 可选 "text" 和 "json"，默认为 "text"
+func (logger *loggerService) SetLogFormat(format string) {
+	logger.format = format
+}
+
+// logLevels 日志类型的级别，info < success < error
+var logLevels = map[int]int{
+	LogTypeInfo:    0,
+	LogTypeSuccess: 1,
+	LogTypeError:   2,
+}
+
+// SetLogLevel 设置写入日志文件的最低级别
+// 可选 "info", "success" 和 "error"，默认为 "info"，即全部写入
+func (logger *loggerService) SetLogLevel(level string) {
+	minLevel := logLevels[LogTypeInfo]
+	switch strings.ToLower(level) {
+	case "", "info":
+	case "success":
+		minLevel = logLevels[LogTypeSuccess]
+	case "error":
+		minLevel = logLevels[LogTypeError]
+	default:
+		Logger.Warnf("unknown log level \"%s\", use info instead.\n", level)
+	}
+	logger.setOptions(func(opts *logOptions) {
+		opts.minLevel = minLevel
+	})
+}
+
+// SetDropBelowLevel 设置低于日志级别的日志是否也不推送给实时日志的连接
+// 默认只是不写入文件，仍然会推送
+func (logger *loggerService) SetDropBelowLevel(drop bool) {
+	logger.setOptions(func(opts *logOptions) {
+		opts.dropStream = drop
+	})
+}
+
+// SetMaxLogSize 设置单个日志文件的最大大小(MB)
+// 超过之后切分成 2006-01-02.N.log，mb <= 0 时只按日期切分
+func (logger *loggerService) SetMaxLogSize(mb int) {
+	logger.setOptions(func(opts *logOptions) {
+		opts.maxSize = int64(mb) << 20
+	})
+}
+
+// AddHook 添加一个logrus钩子，可以把日志发送到 Sentry、webhook 等外部服务
+// 钩子在 Add 中同步调用，耗时的操作请在钩子内部异步处理
+// 只有写入日志文件的日志会触发钩子，日志类型保存在 entry.Data["type"] 中
+// Initialize 之前和之后都可以调用
+func (logger *loggerService) AddHook(hook logrus.Hook) {
+	logger.hooks = append(logger.hooks, hook)
+	for _, entry := range []*logrus.Entry{logger.logS, logger.logI, logger.logE} {
+		if entry != nil {
+			entry.Logger.AddHook(hook)
+		}
+	}
+}
+
+// SetMaskIPs 设置是否在日志中屏蔽ip地址，默认屏蔽
+func (logger *loggerService) SetMaskIPs(mask bool) {
+	logger.setOptions(func(opts *logOptions) {
+		opts.showIPs = !mask
+	})
+}
+
+// MaskIPs 按照日志的规则屏蔽文本中的ip地址
+// 设置了不屏蔽ip时原样返回
+func (logger *loggerService) MaskIPs(text string) string {
+	if logger.options().showIPs {
+		return text
+	}
+	return escapeHost(text)
+}
+
 // LogsPath 获取logs文件的绝对路径
 func (logger *loggerService) LogsPath() string {
 	pwd, _ := os.Getwd()
@@ -135,7 +292,12 @@ func (logger *loggerService) LogsPath() string {
 
 // LogFile 获取当前log文件的位置
 func (logger *loggerService) LogFile(scope string) string {
-	date := time.Now().Format(logDateFormat)
+	return logger.logFileAt(time.Now(), scope)
+}
+
+// logFileAt 获取某一天的log文件的位置
+func (logger *loggerService) logFileAt(day time.Time, scope string) string {
+	date := day.Format(logDateFormat)
 	filename := fmt.Sprintf("%s.log", date)
 	if len(scope) != 0 {
 		filename = fmt.Sprintf("%s-%s.log", date, scope)
@@ -145,88 +307,427 @@ func (logger *loggerService) LogFile(scope string) string {
 
 // Success 获取成功计数
 func (logger *loggerService) SuccessCnt() int {
-	return logger.success
+	return int(atomic.LoadInt64(&logger.success))
 }
 
 // Success 获取失败计数
 func (logger *loggerService) FailCnt() int {
-	return logger.fails
+	return int(atomic.LoadInt64(&logger.fails))
+}
+
+// Recent 获取保存在内存里的最近日志，从旧到新排列
+// 返回的是副本，修改它不会影响缓冲区
+func (logger *loggerService) Recent() []*Log {
+	if logger.recent == nil {
+		return []*Log{}
+	}
+	logs := logger.recentLogs()
+	for i, lg := range logs {
+		cp := *lg
+		logs[i] = &cp
+	}
+	return logs
+}
+
+// recentLogs 按从旧到新的顺序返回缓冲区里的日志
+func (logger *loggerService) recentLogs() []*Log {
+	items := logger.recent.List()
+	logs := make([]*Log, len(items))
+	for i, item := range items {
+		logs[i] = item.(*Log)
+	}
+	return logs
+}
+
+// logFileWriter 带缓冲的日志文件，记录写入的字节数用于按大小切分
+// 缓冲区由 flushLoop 定时写入磁盘，切分和关闭时也会写入
+type logFileWriter struct {
+	fp  *os.File
+	buf *bufio.Writer
+	n   int64
+	mu  sync.Mutex
+}
+
+func (lw *logFileWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	n, err := lw.buf.Write(p)
+	atomic.AddInt64(&lw.n, int64(n))
+	return n, err
+}
+
+// size 文件当前的大小，包括还在缓冲区里的部分
+func (lw *logFileWriter) size() int64 {
+	return atomic.LoadInt64(&lw.n)
+}
+
+// flush 把缓冲区写入文件
+func (lw *logFileWriter) flush() error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.buf.Flush()
+}
+
+// close 写入缓冲区并关闭文件
+func (lw *logFileWriter) close() error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	err := lw.buf.Flush()
+	if cerr := lw.fp.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// openLogFile 以追加的方式打开日志文件
+func openLogFile(name string) *logFileWriter {
+	fp, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		Logger.Fatalln(err)
+	}
+	out := &logFileWriter{fp: fp, buf: bufio.NewWriterSize(fp, logBufferSize)}
+	if stat, err := fp.Stat(); err == nil {
+		out.n = stat.Size()
+	}
+	return out
+}
+
+// sizeRolledName 找到下一个可用的按大小切分的文件名
+// 2006-01-02.log -> 2006-01-02.1.log, 2006-01-02.2.log ...
+func sizeRolledName(name string) string {
+	base := strings.TrimSuffix(name, ".log")
+	for seq := 1; ; seq++ {
+		rolled := fmt.Sprintf("%s.%d.log", base, seq)
+		_, err := os.Stat(rolled)
+		_, gzErr := os.Stat(rolled + ".gz")
+		if os.IsNotExist(err) && os.IsNotExist(gzErr) {
+			return rolled
+		}
+	}
+}
+
+// rollBySize 把超过大小限制的日志文件改名，返回改名后的文件名
+func rollBySize(out *logFileWriter) string {
+	name := out.fp.Name()
+	if err := out.close(); err != nil {
+		Logger.Fatalln(err)
+	}
+	rolled := sizeRolledName(name)
+	if err := os.Rename(name, rolled); err != nil {
+		Logger.Errorf("failed to roll log file %s: %v\n", name, err)
+		return ""
+	}
+	return rolled
 }
 
 func (logger *loggerService) sLogFiles() {
-	var err error
-	var newfp *os.File
-	var oldfp *os.File
+	logger.rollLock.Lock()
+	defer logger.rollLock.Unlock()
+	opts := logger.options()
+	var rolled []string
 	logfileN := logger.LogFile("")
 	if logfileN != logger.logLT {
 		logger.logLT = logfileN
 
-		oldfp = logger.fpSI
-		newfp, err = os.OpenFile(logfileN, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
-		if err != nil {
-			Logger.Fatalln(err)
+		if logger.outSI != nil {
+			if err := logger.outSI.close(); err != nil {
+				Logger.Fatalln(err)
+			}
+			rolled = append(rolled, logger.outSI.fp.Name())
 		}
-		if oldfp != nil {
-			err = oldfp.Close()
-			if err != nil {
+		logger.outSI = openLogFile(logfileN)
+		logger.logS.Logger.SetOutput(logger.outSI)
+		logger.logI.Logger.SetOutput(logger.outSI)
+
+		if logger.outE != nil {
+			if err := logger.outE.close(); err != nil {
 				Logger.Fatalln(err)
 			}
+			rolled = append(rolled, logger.outE.fp.Name())
 		}
-		logger.logS.Logger.SetOutput(newfp)
-		logger.logI.Logger.SetOutput(newfp)
-		logger.fpSI = newfp
+		logger.outE = openLogFile(logger.LogFile("error"))
+		logger.logE.Logger.SetOutput(logger.outE)
 
-		oldfp = logger.fpE
-		newfp, err = os.OpenFile(logger.LogFile("error"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
-		if err != nil {
-			Logger.Fatalln(err)
+		if opts.keepDays > 0 {
+			go logger.removeOldLogs(opts.keepDays)
 		}
-		if oldfp != nil {
-			err = oldfp.Close()
-			if err != nil {
-				Logger.Fatalln(err)
+	} else if opts.maxSize > 0 {
+		// 同一天内的文件超过大小限制时切分成 2006-01-02.N.log
+		if logger.outSI.size() >= opts.maxSize {
+			if name := rollBySize(logger.outSI); name != "" {
+				rolled = append(rolled, name)
+			}
+			logger.outSI = openLogFile(logfileN)
+			logger.logS.Logger.SetOutput(logger.outSI)
+			logger.logI.Logger.SetOutput(logger.outSI)
+		}
+		if logger.outE.size() >= opts.maxSize {
+			if name := rollBySize(logger.outE); name != "" {
+				rolled = append(rolled, name)
 			}
+			logger.outE = openLogFile(logger.LogFile("error"))
+			logger.logE.Logger.SetOutput(logger.outE)
+		}
+	}
+	// 在后台压缩切分出来的日志，不阻塞新文件的写入
+	if opts.compress && len(rolled) > 0 {
+		go compressLogFiles(rolled)
+	}
+}
+
+// flush 把日志文件的缓冲区写入磁盘
+func (logger *loggerService) flush() {
+	logger.rollLock.Lock()
+	defer logger.rollLock.Unlock()
+	for _, out := range []*logFileWriter{logger.outSI, logger.outE} {
+		if out == nil {
+			continue
+		}
+		if err := out.flush(); err != nil {
+			Logger.Errorln("failed to flush log file:", err)
+		}
+	}
+}
+
+// flushLoop 定时把日志文件的缓冲区写入磁盘，直到 Close
+// 开启了计数器保存时也定时保存计数器
+func (logger *loggerService) flushLoop() {
+	ticker := time.NewTicker(logFlushInterval)
+	defer ticker.Stop()
+	var saveC <-chan time.Time
+	if logger.persist {
+		saveTicker := time.NewTicker(countersSaveInterval)
+		defer saveTicker.Stop()
+		saveC = saveTicker.C
+	}
+	for {
+		select {
+		case <-logger.flushQuit:
+			return
+		case <-ticker.C:
+			logger.flush()
+		case <-saveC:
+			logger.saveCounters()
+		}
+	}
+}
+
+// compressLogFiles 把日志文件压缩成 .gz 并删除原文件
+// 压缩文件已经存在时追加为新的gzip成员，解压后内容依次相连
+func compressLogFiles(names []string) {
+	for _, name := range names {
+		if err := compressLogFile(name); err != nil {
+			Logger.Errorf("failed to compress log file %s: %v\n", name, err)
+			continue
+		}
+		if err := os.Remove(name); err != nil {
+			Logger.Errorf("failed to remove compressed log file %s: %v\n", name, err)
 		}
-		logger.logE.Logger.SetOutput(newfp)
-		logger.fpE = newfp
 	}
 }
 
+func compressLogFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	zw := gzip.NewWriter(dst)
+	zw.Name = path.Base(name)
+	if _, err := io.Copy(zw, src); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return dst.Sync()
+}
+
+// removeOldLogs 删除超过保留天数的日志文件
+// 只处理文件名以日期开头的 .log 和 .log.gz 文件
+func (logger *loggerService) removeOldLogs(keepDays int) {
+	logspath := logger.LogsPath()
+	files, err := ioutil.ReadDir(logspath)
+	if err != nil {
+		Logger.Errorln("failed to read logsPath:", err)
+		return
+	}
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	deadline := today.AddDate(0, 0, -keepDays)
+	for _, file := range files {
+		name := file.Name()
+		isLog := strings.HasSuffix(name, ".log") || strings.HasSuffix(name, ".log.gz")
+		if file.IsDir() || !isLog || len(name) < len(logDateFormat) {
+			continue
+		}
+		date, err := time.ParseInLocation(logDateFormat, name[:len(logDateFormat)], time.Local)
+		if err != nil || !date.Before(deadline) {
+			continue
+		}
+		if err := os.Remove(path.Join(logspath, name)); err != nil {
+			Logger.Errorln("failed to remove old log file:", err)
+			continue
+		}
+		Logger.Printf("old log file %s is removed\n", name)
+	}
+}
+
+// 日志转义使用的正则，只编译一次
+var (
+	crPattern   = regexp.MustCompile(`\r`)
+	lfPattern   = regexp.MustCompile(`\n`)
+	hostPattern = regexp.MustCompile(`(\d{1,3})\.(\d{1,3})\.(\d{1,3})\.(\d{1,3})(?::(\d{1,5}))?`)
+	ipv6Pattern = regexp.MustCompile(`\[([0-9a-fA-F:.]+)\](?::(\d{1,5}))?`)
+)
+
 func escapeCRLF(s string) string {
-	cr, _ := regexp.Compile(`\r`)
-	lf, _ := regexp.Compile(`\n`)
-	s = cr.ReplaceAllString(s, "\\r")
-	s = lf.ReplaceAllString(s, "\\n")
+	s = crPattern.ReplaceAllString(s, "\\r")
+	s = lfPattern.ReplaceAllString(s, "\\n")
 	return s
 }
 
+// isHostBoundary 判断匹配到的ip前后是否是合法的边界
+// 避免把 v1.2.3.4 或者 1.2.3.4.5 之类的版本号当成ip
+func isHostBoundary(s string, start, end int) bool {
+	if start > 0 {
+		prev := s[start-1]
+		if prev == '.' || prev == '_' || isAlnum(prev) {
+			return false
+		}
+	}
+	if end < len(s) {
+		next := s[end]
+		if isAlnum(next) {
+			return false
+		}
+		if next == '.' && end+1 < len(s) && isDigit(s[end+1]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlnum(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// escapeHost 屏蔽日志中的ip地址
+// ipv4 保留首尾两段，如 127.*.*.1:8080
+// ipv6 只处理带方括号的形式，保留首尾两组，如 [2001:*:7334]:8080
 func escapeHost(s string) string {
-	host, _ := regexp.Compile(`(\d+)\.\d+\.\d+\.(\d+)(?:\:(\d+))?`)
-	s = host.ReplaceAllString(s, "$1.*.*.$2:$3")
-	return s
+	return escapeIPv4(escapeIPv6(s))
+}
+
+func escapeIPv6(s string) string {
+	return ipv6Pattern.ReplaceAllStringFunc(s, func(m string) string {
+		sub := ipv6Pattern.FindStringSubmatch(m)
+		if !strings.Contains(sub[1], ":") {
+			return m
+		}
+		ip := net.ParseIP(sub[1])
+		if ip == nil {
+			return m
+		}
+		ip = ip.To16()
+		first := uint16(ip[0])<<8 | uint16(ip[1])
+		last := uint16(ip[14])<<8 | uint16(ip[15])
+		masked := fmt.Sprintf("[%x:*:%x]", first, last)
+		if sub[2] != "" {
+			masked += ":" + sub[2]
+		}
+		return masked
+	})
+}
+
+func escapeIPv4(s string) string {
+	matches := hostPattern.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s
+	}
+	buff := new(strings.Builder)
+	last := 0
+	for _, loc := range matches {
+		if !isHostBoundary(s, loc[0], loc[1]) || !isIPv4(s, loc) {
+			continue
+		}
+		buff.WriteString(s[last:loc[0]])
+		buff.WriteString(fmt.Sprintf("%s.*.*.%s", s[loc[2]:loc[3]], s[loc[8]:loc[9]]))
+		// 只有带端口时才输出端口
+		if loc[10] >= 0 {
+			buff.WriteString(":" + s[loc[10]:loc[11]])
+		}
+		last = loc[1]
+	}
+	buff.WriteString(s[last:])
+	return buff.String()
+}
+
+// isIPv4 检查匹配到的四段数字是否都在 0-255 之间
+func isIPv4(s string, loc []int) bool {
+	for i := 1; i <= 4; i++ {
+		n, err := strconv.Atoi(s[loc[2*i]:loc[2*i+1]])
+		if err != nil || n > 255 {
+			return false
+		}
+	}
+	return true
 }
 
 // Add 往队列里加入一个新的log
 func (logger *loggerService) Add(lg *Log) {
+	logger.closeLock.RLock()
+	defer logger.closeLock.RUnlock()
+	if logger.isClosed {
+		return
+	}
 	logger.sLogFiles()
-	lg.Text = escapeHost(lg.Text)
+	opts := logger.options()
+	if !opts.showIPs {
+		lg.Text = escapeHost(lg.Text)
+	}
 	logMsg := escapeCRLF(lg.Text)
+	// 低于日志级别的日志不写入文件
+	toFile := logLevels[lg.Type] >= opts.minLevel
 	switch lg.Type {
 	case LogTypeSuccess:
-		logger.success++
+		atomic.AddInt64(&logger.success, 1)
 		Logger.WithField("type", "success").Println(logMsg)
-		logger.logS.Println(lg.Text)
+		if toFile {
+			logger.logS.Println(lg.Text)
+		}
 	case LogTypeError:
-		logger.fails++
+		atomic.AddInt64(&logger.fails, 1)
 		Logger.WithField("type", "error").Errorln(logMsg)
-		logger.logE.Println(lg.Text)
+		logger.alert.observe(lg.Text)
+		if toFile {
+			logger.logE.Println(lg.Text)
+		}
 	default:
 		Logger.WithField("type", "info").Println(logMsg)
-		logger.logI.Println(lg.Text)
+		if toFile {
+			logger.logI.Println(lg.Text)
+		}
+	}
+	if !toFile && opts.dropStream {
+		return
 	}
-	logger.logChan <- lg
-	if len(logger.logChan) >= maxQueueSize {
-		<-logger.logChan
+	logger.recent.Push(lg)
+	// 只有在有客户端连接时才推送实时日志，客户端过慢时丢弃
+	if logger.connCount() > 0 {
+		select {
+		case logger.logChan <- lg:
+		default:
+		}
 	}
 }
 
@@ -275,40 +776,84 @@ func (logger *loggerService) Errorf(format string, args ...interface{}) {
 	logger.AddLog(LogTypeError, fmt.Sprintf(format, args...))
 }
 
-func (logger *loggerService) setConn(conn *websocket.Conn, state bool) {
+func (logger *loggerService) addClient(client logSubscriber) {
 	logger.wscLock.Lock()
 	defer logger.wscLock.Unlock()
-	logger.conns[conn] = state
+	logger.conns[client] = true
 }
 
-func (logger *loggerService) delConn(conn *websocket.Conn) {
+func (logger *loggerService) connCount() int {
 	logger.wscLock.Lock()
 	defer logger.wscLock.Unlock()
-	delete(logger.conns, conn)
-}
-
-func setupPong(conn *websocket.Conn, quit chan int) {
-	pongTicker := time.NewTicker(pongWaitTime)
-	pongMsg := []byte("")
-	go func() {
-		defer pongTicker.Stop()
-		defer conn.Close()
-		defer Service.delConn(conn)
-		for {
-			if Service.conns[conn] != true {
-				close(quit)
-			}
-			select {
-			case <-quit:
-				return
-			case <-pongTicker.C:
-				conn.SetWriteDeadline(time.Now().Add(pongWaitTime))
-				if err := conn.WriteMessage(websocket.PongMessage, pongMsg); err != nil {
-					close(quit)
-				}
+	return len(logger.conns)
+}
+
+func (logger *loggerService) delClient(client logSubscriber) {
+	logger.wscLock.Lock()
+	defer logger.wscLock.Unlock()
+	delete(logger.conns, client)
+}
+
+// broadcast 把管道里的日志分发到每个连接的发送队列
+// 每条日志只序列化一次，已经断开的连接会被移除
+func (logger *loggerService) broadcast() {
+	for lg := range logger.logChan {
+		msg, err := prepareLog(lg)
+		if err != nil {
+			Logger.Errorln("failed to marshal log:", err)
+			continue
+		}
+		logger.wscLock.Lock()
+		for client := range logger.conns {
+			if client.closed() {
+				delete(logger.conns, client)
+				continue
 			}
+			client.enqueue(msg)
+		}
+		logger.wscLock.Unlock()
+	}
+}
+
+// Close 关闭logger服务
+// 关闭所有的websocket连接、log管道和日志文件，之后的日志都会被忽略
+func (logger *loggerService) Close() error {
+	logger.closeLock.Lock()
+	defer logger.closeLock.Unlock()
+	if logger.isClosed {
+		return nil
+	}
+	logger.isClosed = true
+	logger.wscLock.Lock()
+	for client := range logger.conns {
+		client.close()
+		delete(logger.conns, client)
+	}
+	logger.wscLock.Unlock()
+	if logger.logChan != nil {
+		close(logger.logChan)
+	}
+	if logger.flushQuit != nil {
+		close(logger.flushQuit)
+	}
+	// 关闭文件前写入缓冲区里的日志
+	logger.rollLock.Lock()
+	defer logger.rollLock.Unlock()
+	var err error
+	for _, out := range []*logFileWriter{logger.outSI, logger.outE} {
+		if out == nil {
+			continue
 		}
-	}()
+		if cerr := out.close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	logger.outSI = nil
+	logger.outE = nil
+	if logger.persist {
+		logger.saveCounters()
+	}
+	return err
 }
 
 // Initialize 初始化logger服务
@@ -331,10 +876,19 @@ func (logger *loggerService) Initialize() {
 			Logger.Println("logsPath created successfully.")
 		}
 	}
+	if logger.persist {
+		logger.loadCounters()
+	}
 	// 创建连接池
-	logger.conns = make(map[*websocket.Conn]bool)
+	logger.conns = make(map[logSubscriber]bool)
+	if logger.replay <= 0 {
+		logger.replay = maxQueueSize
+	}
 	// 创建log管道
-	logger.logChan = make(chan *Log, maxQueueSize)
+	logger.logChan = make(chan *Log, logger.replay)
+	// 创建最近日志的缓冲区
+	logger.recent = NewRing(logger.replay)
+	go logger.broadcast()
 	// 创建 logrus success 实例
 	logger.logS = logrus.New().WithFields(logrus.Fields{
 		"name": "haruno",
@@ -350,8 +904,26 @@ func (logger *loggerService) Initialize() {
 		"name": "haruno",
 		"type": "error",
 	})
-	logger.logS.Logger.SetFormatter(&logrus.TextFormatter{})
-	logger.logI.Logger.SetFormatter(&logrus.TextFormatter{})
-	logger.logE.Logger.SetFormatter(&logrus.TextFormatter{})
+	var formatter logrus.Formatter
+	switch strings.ToLower(logger.format) {
+	case "json":
+		formatter = &logrus.JSONFormatter{}
+	case "", "text":
+		formatter = &logrus.TextFormatter{}
+	default:
+		Logger.Warnf("unknown log format \"%s\", use text instead.\n", logger.format)
+		formatter = &logrus.TextFormatter{}
+	}
+	logger.logS.Logger.SetFormatter(formatter)
+	logger.logI.Logger.SetFormatter(formatter)
+	logger.logE.Logger.SetFormatter(formatter)
+	// Initialize 之前添加的钩子
+	for _, hook := range logger.hooks {
+		logger.logS.Logger.AddHook(hook)
+		logger.logI.Logger.AddHook(hook)
+		logger.logE.Logger.AddHook(hook)
+	}
 	logger.sLogFiles()
+	logger.flushQuit = make(chan struct{})
+	go logger.flushLoop()
 }
@@ -0,0 +1,349 @@
+package logger
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestMain 在临时目录中运行测试，日志文件不会写到源码目录
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "haruno-logger")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTestService 创建一个写入 logsPath 目录的日志服务
+func newTestService(t testing.TB, logsPath string) *loggerService {
+	t.Helper()
+	service := new(loggerService)
+	service.SetLogsPath(logsPath)
+	service.Initialize()
+	return service
+}
+
+func TestSetOptionsWhileLogging(t *testing.T) {
+	defer discardConsole()()
+	service := newTestService(t, "options")
+	defer service.Close()
+	// 默认屏蔽ip
+	service.Info("default from 192.168.1.20:6700")
+	if got := lastLog(service).Text; got != "default from 192.*.*.20:6700" {
+		t.Errorf("ip is not masked by default: %q", got)
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			service.Infof("log %d from 10.0.0.1", i)
+			service.Errorf("error %d", i)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			service.SetRetentionDays(i % 3)
+			service.SetCompressLogs(i%2 == 0)
+			service.SetMaxLogSize(i % 2)
+			service.SetLogLevel("error")
+			service.SetDropBelowLevel(i%2 == 1)
+			service.SetMaskIPs(i%2 == 0)
+		}
+	}()
+	wg.Wait()
+	service.SetLogLevel("success")
+	service.SetMaskIPs(false)
+	opts := service.options()
+	if opts.minLevel != logLevels[LogTypeSuccess] || !opts.showIPs {
+		t.Errorf("options are not applied: %+v", opts)
+	}
+	service.Success("shown from 192.168.1.20:6700")
+	if got := lastLog(service).Text; got != "shown from 192.168.1.20:6700" {
+		t.Errorf("ip is masked after SetMaskIPs(false): %q", got)
+	}
+	service.flush()
+	content, err := ioutil.ReadFile(service.LogFile(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"default from 192.*.*.20:6700", "shown from 192.168.1.20:6700"} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("log file does not contain %q", want)
+		}
+	}
+	if strings.Contains(string(content), "default from 192.168") {
+		t.Error("masked ip is written to the log file")
+	}
+}
+
+// lastLog 最近的一条日志
+func lastLog(service *loggerService) *Log {
+	logs := service.Recent()
+	return logs[len(logs)-1]
+}
+
+func TestEscapeHostIPv4(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"connect to 192.168.1.20:6700", "connect to 192.*.*.20:6700"},
+		{"connect to 192.168.1.20 failed", "connect to 192.*.*.20 failed"},
+		{"192.168.1.20.", "192.*.*.20."},
+		{"ws://127.0.0.1:8080/api", "ws://127.*.*.1:8080/api"},
+		{"from 10.0.0.1, 10.0.0.2", "from 10.*.*.1, 10.*.*.2"},
+		// 不是ip的情况不处理
+		{"go-cqhttp v1.2.3.4", "go-cqhttp v1.2.3.4"},
+		{"version 1.2.3.4.5", "version 1.2.3.4.5"},
+		{"build_1.2.3.4", "build_1.2.3.4"},
+		{"300.1.1.1", "300.1.1.1"},
+		{"1.2.3", "1.2.3"},
+		{"no ip here: 12", "no ip here: 12"},
+	}
+	for _, c := range cases {
+		if got := escapeHost(c.in); got != c.want {
+			t.Errorf("escapeHost(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestEscapeHostIPv6(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"listen on [::1]", "listen on [0:*:1]"},
+		{"listen on [::1]:6700", "listen on [0:*:1]:6700"},
+		{"[2001:0db8:85a3:0000:0000:8a2e:0370:7334]", "[2001:*:7334]"},
+		{"ws://[2001:db8::8a2e:370:7334]:8080/", "ws://[2001:*:7334]:8080/"},
+		{"[fe80::1%eth0]", "[fe80::1%eth0]"},
+		// 不是ipv6的方括号内容不处理
+		{"[CQ:at,qq=1]", "[CQ:at,qq=1]"},
+		{"[12:34]", "[12:34]"},
+		{"[1234]", "[1234]"},
+		// ipv4 的处理不受影响
+		{"[::1] and 127.0.0.1:80", "[0:*:1] and 127.*.*.1:80"},
+	}
+	for _, c := range cases {
+		if got := escapeHost(c.in); got != c.want {
+			t.Errorf("escapeHost(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+// discardConsole 在基准测试期间不输出控制台日志，返回恢复的函数
+func discardConsole() func() {
+	out := Logger.Logger.Out
+	Logger.Logger.SetOutput(ioutil.Discard)
+	return func() {
+		Logger.Logger.SetOutput(out)
+	}
+}
+
+func BenchmarkAdd(b *testing.B) {
+	defer discardConsole()()
+	service := newTestService(b, "bench-add")
+	defer service.Close()
+	text := "connect to ws://192.168.1.20:6700 failed\nretry after 1s"
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		service.AddLog(LogTypeInfo, text)
+	}
+}
+
+func BenchmarkEscape(b *testing.B) {
+	text := "connect to ws://192.168.1.20:6700 and [::1]:8080 failed\r\nretry after 1s"
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		escapeCRLF(escapeHost(text))
+	}
+}
+
+// benchmarkFileWrite 通过 logrus 往 out 写日志
+func benchmarkFileWrite(b *testing.B, out io.Writer) {
+	lg := logrus.New()
+	lg.SetOutput(out)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lg.Println("[12:00:00] plugin echo received a message from group 123456")
+	}
+}
+
+func BenchmarkFileWrite(b *testing.B) {
+	if err := os.MkdirAll("bench-write", 0700); err != nil {
+		b.Fatal(err)
+	}
+	b.Run("Buffered", func(b *testing.B) {
+		out := openLogFile("bench-write/buffered.log")
+		defer out.close()
+		benchmarkFileWrite(b, out)
+	})
+	b.Run("Unbuffered", func(b *testing.B) {
+		fp, err := os.OpenFile("bench-write/unbuffered.log", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer fp.Close()
+		benchmarkFileWrite(b, fp)
+	})
+}
+
+// waitGoroutines 等待协程数回到 n 以下，超时返回 false
+func waitGoroutines(n int) bool {
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+func TestCloseFlushesAndClosesFiles(t *testing.T) {
+	defer discardConsole()()
+	before := runtime.NumGoroutine()
+	service := newTestService(t, "close")
+	service.Info("info before close")
+	service.Error("error before close")
+	outSI, outE := service.outSI, service.outE
+	if err := service.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for out, want := range map[*logFileWriter]string{outSI: "info before close", outE: "error before close"} {
+		content, err := ioutil.ReadFile(out.fp.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(content), want) {
+			t.Errorf("%s is not flushed, content: %q", out.fp.Name(), content)
+		}
+		if _, err := out.fp.Write([]byte("x")); err == nil {
+			t.Errorf("%s is still open", out.fp.Name())
+		}
+	}
+	if !waitGoroutines(before) {
+		t.Errorf("goroutines are still running after close: %d, want %d", runtime.NumGoroutine(), before)
+	}
+	// 关闭之后的日志被忽略，重复关闭没有影响
+	service.Info("info after close")
+	if err := service.Close(); err != nil {
+		t.Errorf("second close failed: %v", err)
+	}
+}
+
+func TestRemoveOldLogs(t *testing.T) {
+	defer discardConsole()()
+	dir, err := ioutil.TempDir(".", "retention")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := new(loggerService)
+	service.SetLogsPath(dir)
+	day := func(offset int) string {
+		return time.Now().AddDate(0, 0, offset).Format(logDateFormat)
+	}
+	removed := []string{
+		day(-10) + ".log",
+		day(-4) + "-error.log",
+		day(-4) + ".log.gz",
+		day(-5) + ".1.log.gz",
+	}
+	kept := []string{
+		// 刚好在保留天数内
+		day(-3) + ".log",
+		day(-1) + "-error.log.gz",
+		day(0) + ".log",
+		day(0) + ".2.log",
+		// 不是日志文件或者文件名不以日期开头
+		day(-10) + ".txt",
+		day(-10) + ".log.bak",
+		"counters.json",
+		"readme.log",
+		"old.log.gz",
+	}
+	for _, name := range append(removed, kept...) {
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte("log\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 以日期命名的目录也不会被删除
+	oldDir := day(-11) + ".log"
+	if err := os.Mkdir(path.Join(dir, oldDir), 0700); err != nil {
+		t.Fatal(err)
+	}
+	kept = append(kept, oldDir)
+	service.removeOldLogs(3)
+	for _, name := range removed {
+		if _, err := os.Stat(path.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s should be removed", name)
+		}
+	}
+	for _, name := range kept {
+		if _, err := os.Stat(path.Join(dir, name)); err != nil {
+			t.Errorf("%s should be kept: %v", name, err)
+		}
+	}
+}
+
+func TestRollBySize(t *testing.T) {
+	defer discardConsole()()
+	service := newTestService(t, "roll")
+	defer service.Close()
+	service.SetMaxLogSize(1)
+	current := service.LogFile("")
+	rolled := strings.TrimSuffix(current, ".log") + ".1.log"
+	text := strings.Repeat("x", 1024)
+	for i := 0; ; i++ {
+		if _, err := os.Stat(rolled); err == nil {
+			break
+		}
+		if i > 4096 {
+			t.Fatalf("%s is not created after writing %d KB", rolled, i)
+		}
+		service.Infof("%d %s", i, text)
+	}
+	service.Info("after roll")
+	service.flush()
+	stat, err := os.Stat(rolled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() < 1<<20 {
+		t.Errorf("%s is rolled at %d bytes, want at least 1 MB", rolled, stat.Size())
+	}
+	// 切分之后的日志继续写入当天的日志文件
+	content, err := ioutil.ReadFile(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "after roll") {
+		t.Errorf("log after rolling is not written to %s", current)
+	}
+	content, err = ioutil.ReadFile(rolled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "after roll") {
+		t.Errorf("log after rolling is written to %s", rolled)
+	}
+	if _, err := os.Stat(strings.TrimSuffix(current, ".log") + ".2.log"); !os.IsNotExist(err) {
+		t.Error("log file is rolled more than once")
+	}
+}
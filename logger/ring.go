@@ -0,0 +1,75 @@
+package logger
+
+import "sync"
+
+// Ring 固定大小的环形缓冲区，可以在多个协程中同时使用
+// 满了之后最旧的元素先被淘汰，日志服务用它保存最近的日志
+type Ring struct {
+	mu    sync.Mutex
+	buf   []interface{}
+	start int
+	size  int
+}
+
+// NewRing 创建一个最多保存 capacity 个元素的缓冲区
+// capacity <= 0 时不保存任何元素
+func NewRing(capacity int) *Ring {
+	if capacity < 0 {
+		capacity = 0
+	}
+	return &Ring{
+		buf: make([]interface{}, capacity),
+	}
+}
+
+// Push 加入一个元素
+func (ring *Ring) Push(v interface{}) {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	capacity := len(ring.buf)
+	if capacity == 0 {
+		return
+	}
+	if ring.size < capacity {
+		ring.buf[(ring.start+ring.size)%capacity] = v
+		ring.size++
+		return
+	}
+	ring.buf[ring.start] = v
+	ring.start = (ring.start + 1) % capacity
+}
+
+// List 按从旧到新的顺序返回保存的元素
+func (ring *Ring) List() []interface{} {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	return ring.list()
+}
+
+func (ring *Ring) list() []interface{} {
+	items := make([]interface{}, ring.size)
+	for i := 0; i < ring.size; i++ {
+		items[i] = ring.buf[(ring.start+i)%len(ring.buf)]
+	}
+	return items
+}
+
+// Resize 修改容量，保留最新的元素
+func (ring *Ring) Resize(capacity int) {
+	if capacity < 0 {
+		capacity = 0
+	}
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	if capacity == len(ring.buf) {
+		return
+	}
+	items := ring.list()
+	if len(items) > capacity {
+		items = items[len(items)-capacity:]
+	}
+	ring.buf = make([]interface{}, capacity)
+	copy(ring.buf, items)
+	ring.start = 0
+	ring.size = len(items)
+}
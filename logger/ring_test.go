@@ -0,0 +1,77 @@
+package logger
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func ringInts(ring *Ring) []int {
+	items := ring.List()
+	ints := make([]int, len(items))
+	for i, item := range items {
+		ints[i] = item.(int)
+	}
+	return ints
+}
+
+func TestRingKeepsNewest(t *testing.T) {
+	ring := NewRing(3)
+	if got := ringInts(ring); len(got) != 0 {
+		t.Fatalf("new ring should be empty, got %v", got)
+	}
+	for i := 1; i <= 2; i++ {
+		ring.Push(i)
+	}
+	if got := ringInts(ring); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Fatalf("got %v, want [1 2]", got)
+	}
+	for i := 3; i <= 7; i++ {
+		ring.Push(i)
+	}
+	if got := ringInts(ring); !reflect.DeepEqual(got, []int{5, 6, 7}) {
+		t.Fatalf("got %v, want [5 6 7]", got)
+	}
+}
+
+func TestRingResize(t *testing.T) {
+	ring := NewRing(4)
+	for i := 1; i <= 6; i++ {
+		ring.Push(i)
+	}
+	ring.Resize(2)
+	if got := ringInts(ring); !reflect.DeepEqual(got, []int{5, 6}) {
+		t.Fatalf("shrink: got %v, want [5 6]", got)
+	}
+	ring.Resize(4)
+	ring.Push(7)
+	ring.Push(8)
+	ring.Push(9)
+	if got := ringInts(ring); !reflect.DeepEqual(got, []int{6, 7, 8, 9}) {
+		t.Fatalf("grow: got %v, want [6 7 8 9]", got)
+	}
+	ring.Resize(-1)
+	ring.Push(10)
+	if got := ringInts(ring); len(got) != 0 {
+		t.Fatalf("disabled ring should be empty, got %v", got)
+	}
+}
+
+func TestRecentKeepsLastLogs(t *testing.T) {
+	service := newTestService(t, "recent")
+	defer service.Close()
+	total := maxQueueSize*2 + 3
+	for i := 0; i < total; i++ {
+		service.Infof("log %d", i)
+	}
+	logs := service.Recent()
+	if len(logs) != maxQueueSize {
+		t.Fatalf("got %d logs, want %d", len(logs), maxQueueSize)
+	}
+	for i, lg := range logs {
+		want := fmt.Sprintf("log %d", total-maxQueueSize+i)
+		if lg.Text != want {
+			t.Errorf("log %d = %q, want %q", i, lg.Text, want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sseClient 一个日志sse连接
+type sseClient struct {
+	types logFilter
+	send  chan []byte
+	quit  chan struct{}
+	once  sync.Once
+}
+
+func newSSEClient() *sseClient {
+	return &sseClient{
+		send: make(chan []byte, clientQueueSize),
+		quit: make(chan struct{}),
+	}
+}
+
+// enqueue 把日志放入发送队列，队列满了直接丢弃
+func (client *sseClient) enqueue(out *preparedLog) bool {
+	if !client.types.accept(out.typ) {
+		return true
+	}
+	select {
+	case client.send <- out.data:
+		return true
+	default:
+		return false
+	}
+}
+
+// closed 连接是否已经关闭
+func (client *sseClient) closed() bool {
+	select {
+	case <-client.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// close 关闭连接，可以重复调用
+func (client *sseClient) close() {
+	client.once.Do(func() {
+		close(client.quit)
+	})
+}
+
+// writeLoop 把日志以sse事件的格式写入 w，直到连接关闭
+func (client *sseClient) writeLoop(w io.Writer, flush func() error) {
+	ticker := time.NewTicker(pongWaitTime)
+	defer ticker.Stop()
+	defer client.close()
+	for {
+		select {
+		case <-client.quit:
+			return
+		case data := <-client.send:
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			// 注释行，用来保持连接
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+		if err := flush(); err != nil {
+			return
+		}
+	}
+}
+
+// SSELogHandler 以 Server-Sent Events 的方式广播log
+// http/1.x 的连接会被接管，不受http服务的写超时限制
+// 不能接管的连接(比如 http/2)会在写超时之后断开，浏览器会自动重连
+// 可以用 ?type=error,success 只接收某些类型的日志
+func SSELogHandler(w http.ResponseWriter, r *http.Request) {
+	types, err := parseLogFilter(r.URL.Query().Get("type"))
+	if err != nil {
+		http.Error(w, RequestParamError, http.StatusBadRequest)
+		return
+	}
+	client := newSSEClient()
+	enqueueLog(client, NewLog(LogTypeInfo, "Logger服务连接成功!"))
+	client.types = types
+	// 先发送最近的日志
+	for _, lg := range Service.recentLogs() {
+		enqueueLog(client, lg)
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok || r.ProtoMajor != 1 {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, InnerServerError, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		Service.addClient(client)
+		defer Service.delClient(client)
+		go func() {
+			<-r.Context().Done()
+			client.close()
+		}()
+		client.writeLoop(w, func() error {
+			flusher.Flush()
+			return nil
+		})
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		Service.Errorf("Logger SSELogHandler error: %v", err)
+		return
+	}
+	defer conn.Close()
+	// 清除http服务设置的超时
+	conn.SetDeadline(time.Time{})
+	rw.WriteString("HTTP/1.1 200 OK\r\n")
+	rw.WriteString("Content-Type: text/event-stream; charset=utf-8\r\n")
+	rw.WriteString("Cache-Control: no-cache\r\n")
+	rw.WriteString("Connection: close\r\n\r\n")
+	Service.addClient(client)
+	defer Service.delClient(client)
+	// 客户端不会再发送数据，读到错误说明连接已经断开
+	go func() {
+		defer client.close()
+		buff := make([]byte, 1)
+		for {
+			if _, err := rw.Read(buff); err != nil {
+				return
+			}
+		}
+	}()
+	client.writeLoop(rw, rw.Flush)
+}
@@ -1,4 +1,5 @@
-// +build linux darwin
+//go:build !windows
+// +build !windows
 
 package sys
 